@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Handlers in this file are wrapped with requirePermission in main
+
+func deletePostHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	postId, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	event := removalEvent(CONTENT_POST, postId)
+	err = deletePost(postId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	publishFeedEvent(event)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func deleteCommentHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	commentId, err := strconv.Atoi(r.FormValue("comment_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	event := removalEvent(CONTENT_COMMENT, commentId)
+	err = deleteComment(commentId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	publishFeedEvent(event)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func addCategoryHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	category := strings.TrimSpace(r.FormValue("category"))
+	resp.Error = validateCategory(category)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := insertCategories([]string{category})
+	if err != nil {
+		resp.Error = categorySaveError(err)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func renameCategoryHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	category := r.FormValue("category")
+	newCategory := strings.TrimSpace(r.FormValue("new_category"))
+	resp.Error = validateCategory(newCategory)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := renameCategory(category, newCategory)
+	if err != nil {
+		resp.Error = categorySaveError(err)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func removeCategoryHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := removeCategory(r.FormValue("category"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// List all users with their roles
+func usersHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	users, err := getUsersWithRoles()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = users
+	json.NewEncoder(w).Encode(resp)
+}
+
+func setRoleHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Error = changeRole(userId, r.FormValue("role"))
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Set role of user. Last administrator cannot be demoted
+func changeRole(userId int, role string) *Error {
+	if !isValidRole(role) {
+		return &Error{Type: INVALID_INPUT, Message: "Error: unknown role"}
+	}
+
+	target, err := getUserById(userId)
+	if err != nil {
+		return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if target == nil {
+		return &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+	}
+
+	if target.Role == ROLE_ADMIN && role != ROLE_ADMIN {
+		admins, err := countUsersWithRole(ROLE_ADMIN)
+		if err != nil {
+			return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		}
+		if admins <= 1 {
+			return &Error{Type: FORBIDDEN, Message: "Error: there must be at least one administrator"}
+		}
+	}
+
+	err = updateRole(userId, role)
+	if err != nil {
+		return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+
+	if client, ok := getClient(userId); ok {
+		client.setRole(role)
+	}
+	return nil
+}
+
+func validateCategory(category string) *Error {
+	if len(category) < 1 || len(category) > 50 {
+		return &Error{Type: INVALID_INPUT, Message: "Error: category should be between 1 and 50 characters long"}
+	}
+	return nil
+}
+
+func categorySaveError(err error) *Error {
+	if strings.HasPrefix(err.Error(), "UNIQUE constraint failed: categories.category") {
+		return &Error{Type: INVALID_INPUT, Message: "Error: category already exists"}
+	}
+	return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+}
+
+// Pin post with pinned=true, unpin with pinned=false. Post is pinned
+// in feed of category if category is given, otherwise in all feeds
+func pinPostHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	post, e := postFromForm(r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	pinned := r.FormValue("pinned") != "false"
+	category := strings.TrimSpace(r.FormValue("category"))
+	if pinned && category != "" && !containsString(post.Categories, category) {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: post is not in category " + category}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := pinPost(post.Id, pinned, category)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	post.Pinned = pinned
+	post.PinnedCategory = ""
+	if pinned {
+		post.PinnedCategory = category
+	}
+	publishFeedEvent(postUpdatedEvent(post))
+
+	resp.Payload = post
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Lock post with locked=true, unlock with locked=false
+func lockPostHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	post, e := postFromForm(r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	locked := r.FormValue("locked") != "false"
+
+	err := lockPost(post.Id, locked)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	post.Locked = locked
+	publishFeedEvent(postUpdatedEvent(post))
+
+	resp.Payload = post
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Visible post in form value post_id
+func postFromForm(r *http.Request) (*Post, *Error) {
+	postId, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		return nil, &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	post, err := getPost(postId)
+	if err != nil {
+		return nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if post.Id == 0 {
+		return nil, &Error{Type: CONTENT_NOT_FOUND, Message: "Error: post not found"}
+	}
+	return post, nil
+}
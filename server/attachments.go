@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Longest file name kept for attachment
+const MAX_FILE_NAME_LENGTH = 200
+
+// Form fields other than files may use this many bytes of request body
+const MAX_FORM_SIZE = 1024 * 1024
+
+// Uploaded file that passed validation but is not stored yet
+type Upload struct {
+	FileName string
+	MimeType string
+	Data     []byte
+	Image    *CleanImage
+}
+
+func allowedAttachmentTypes() []string {
+	types := []string{}
+	for _, t := range strings.Split(config.AttachmentTypes, ",") {
+		if strings.TrimSpace(t) != "" {
+			types = append(types, strings.TrimSpace(t))
+		}
+	}
+	return types
+}
+
+// Parse multipart form with files in field "attachments". Has to be called
+// before any form value is read, since it limits size of request body.
+// Type of file is sniffed from its content, name and declared type are not trusted.
+// Images are cleaned of metadata here, so invalid images are rejected before content is saved
+func parseAttachments(w http.ResponseWriter, r *http.Request) ([]*Upload, *Error) {
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.AttachmentMaxCount*config.AttachmentMaxSize+MAX_FORM_SIZE))
+	err := r.ParseMultipartForm(MAX_FORM_SIZE)
+	if err == http.ErrNotMultipart {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: %v", err)}
+	}
+
+	files := r.MultipartForm.File["attachments"]
+	if len(files) > config.AttachmentMaxCount {
+		return nil, &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: at most %v attachments are allowed", config.AttachmentMaxCount)}
+	}
+
+	uploads := []*Upload{}
+	for _, header := range files {
+		if header.Size > int64(config.AttachmentMaxSize) {
+			return nil, &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: %v is too large", header.Filename)}
+		}
+		file, err := header.Open()
+		if err != nil {
+			return nil, &Error{Type: ERROR_READING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(file, int64(config.AttachmentMaxSize)+1))
+		file.Close()
+		if err != nil {
+			return nil, &Error{Type: ERROR_READING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		}
+		if len(data) > config.AttachmentMaxSize {
+			return nil, &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: %v is too large", header.Filename)}
+		}
+
+		upload := Upload{FileName: cleanFileName(header.Filename), Data: data}
+		upload.MimeType, _, err = mime.ParseMediaType(http.DetectContentType(data))
+		if err != nil || !containsString(allowedAttachmentTypes(), upload.MimeType) {
+			return nil, &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: type of %v is not allowed", header.Filename)}
+		}
+		if isImageType(upload.MimeType) {
+			upload.Image, err = cleanImage(data, upload.MimeType)
+			if err != nil {
+				return nil, &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: %v is not a valid image", header.Filename)}
+			}
+			upload.Data = upload.Image.Data
+		}
+		uploads = append(uploads, &upload)
+	}
+	return uploads, nil
+}
+
+// Base name without control characters, shortened to MAX_FILE_NAME_LENGTH
+func cleanFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7F {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		name = "file"
+	}
+	runes := []rune(name)
+	if len(runes) > MAX_FILE_NAME_LENGTH {
+		name = string(runes[:MAX_FILE_NAME_LENGTH])
+	}
+	return name
+}
+
+// Uploads must fit in what is left of user's quota
+func checkStorageQuota(user *User, uploads []*Upload) *Error {
+	if len(uploads) == 0 {
+		return nil
+	}
+	used, err := getUsedStorage(user.Id)
+	if err != nil {
+		return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	for _, upload := range uploads {
+		used += len(upload.Data)
+	}
+	if used > config.AttachmentQuota {
+		return &Error{Type: STORAGE_QUOTA_EXCEEDED, Message: "Error: storage quota exceeded, remove some attachments first"}
+	}
+	return nil
+}
+
+// Store uploads in blob storage and record them as attachments of content.
+// If anything fails, files stored so far are removed
+func saveAttachments(user *User, contentType string, contentId int, uploads []*Upload) ([]Attachment, error) {
+	attachments := []Attachment{}
+	for _, upload := range uploads {
+		a := Attachment{
+			UserId:      user.Id,
+			ContentType: contentType,
+			ContentId:   contentId,
+			FileName:    upload.FileName,
+			MimeType:    upload.MimeType,
+			Size:        len(upload.Data),
+			StorageKey:  "attachments/" + generateSessionId(),
+		}
+		err := storage.Put(a.StorageKey, upload.Data, a.MimeType)
+		if err != nil {
+			removeAttachments(attachments)
+			return nil, err
+		}
+		if upload.Image != nil {
+			a.Width = upload.Image.Width
+			a.Height = upload.Image.Height
+			a.ThumbnailKey = a.StorageKey + "-thumbnail"
+			err = storage.Put(a.ThumbnailKey, upload.Image.Thumbnail, thumbnailType(a.MimeType))
+		}
+		if err == nil {
+			err = insertAttachment(&a)
+		}
+		if err != nil {
+			removeAttachments(append(attachments, a))
+			return nil, err
+		}
+		setAttachmentUrls(&a)
+		attachments = append(attachments, a)
+	}
+	return attachments, nil
+}
+
+// Delete files and records of attachments. Errors are only logged,
+// since content the attachments belong to is removed anyway
+func removeAttachments(attachments []Attachment) {
+	for _, a := range attachments {
+		for _, key := range []string{a.StorageKey, a.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			err := storage.Delete(key)
+			if err != nil {
+				errorHandler(err)
+			}
+		}
+		if a.Id != 0 {
+			err := deleteAttachment(a.Id)
+			if err != nil {
+				errorHandler(err)
+			}
+		}
+	}
+}
+
+func setAttachmentUrls(a *Attachment) {
+	a.Url = "/attachment?attachment_id=" + strconv.Itoa(a.Id)
+	if a.ThumbnailKey != "" {
+		a.ThumbnailUrl = a.Url + "&thumbnail=true"
+	}
+}
+
+// Attachment can be downloaded by users who can see the content it belongs to,
+// attachments of messages only by sender and recipient. Uploader can always
+// download it, also while content is scheduled
+func canViewAttachment(user *User, a *Attachment) (bool, error) {
+	if a.UserId == user.Id {
+		return true, nil
+	}
+	switch a.ContentType {
+	case CONTENT_POST:
+		post, err := getPost(a.ContentId)
+		if err != nil {
+			return false, err
+		}
+		return post.Id != 0, nil
+	case CONTENT_COMMENT:
+		return isCommentVisible(a.ContentId)
+	case CONTENT_MESSAGE:
+		return isMessageVisible(a.ContentId, user.Id)
+	}
+	return false, nil
+}
+
+// Download attachment_id, or its thumbnail if thumbnail is "true"
+func attachmentHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("attachment_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	attachment, err := getAttachment(id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	allowed := false
+	if attachment != nil {
+		allowed, err = canViewAttachment(user, attachment)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+	if !allowed {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: attachment not found"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	key := attachment.StorageKey
+	mimeType := attachment.MimeType
+	if r.FormValue("thumbnail") == "true" && attachment.ThumbnailKey != "" {
+		key = attachment.ThumbnailKey
+		mimeType = thumbnailType(attachment.MimeType)
+	}
+	file, err := storage.Get(key)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_READING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	defer file.Close()
+
+	// Files are served with sniffed type only, and anything but images is downloaded
+	disposition := "attachment"
+	if isImageType(attachment.MimeType) {
+		disposition = "inline"
+	}
+	if d := mime.FormatMediaType(disposition, map[string]string{"filename": attachment.FileName}); d != "" {
+		disposition = d
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", disposition)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	_, err = io.Copy(w, file)
+	if err != nil {
+		errorHandler(err)
+	}
+}
+
+// Storage used by signed in user
+func storageHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	used, err := getUsedStorage(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = StorageUsage{Used: used, Quota: config.AttachmentQuota}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestCanViewAttachment(t *testing.T) {
+	setupTestDB(t)
+	author := createTestUser(t, "uploader")
+	reader := createTestUser(t, "viewer")
+	recipient := createTestUser(t, "recipient")
+	post := createTestPost(t, author, "post")
+	comment := &Comment{UserId: author.Id, PostId: post.Id, Content: "comment"}
+	message := &Message{FromId: author.Id, ToId: recipient.Id, Content: "message"}
+	err := saveComment(comment)
+	if err == nil {
+		err = insertMessage(message)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		_, err := db.Exec(query, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(name string, user *User, a *Attachment, want bool) {
+		t.Helper()
+		got, err := canViewAttachment(user, a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%v: got %v, want %v", name, got, want)
+		}
+	}
+
+	onPost := &Attachment{UserId: author.Id, ContentType: CONTENT_POST, ContentId: post.Id}
+	onComment := &Attachment{UserId: author.Id, ContentType: CONTENT_COMMENT, ContentId: comment.Id}
+	onMessage := &Attachment{UserId: author.Id, ContentType: CONTENT_MESSAGE, ContentId: message.Id}
+	check("post", reader, onPost, true)
+	check("comment", reader, onComment, true)
+	check("message to recipient", recipient, onMessage, true)
+	check("message to others", reader, onMessage, false)
+
+	exec("UPDATE posts SET hidden = 1 WHERE id = ?", post.Id)
+	check("post hidden", reader, onPost, false)
+	check("comment of hidden post", reader, onComment, false)
+	check("uploader of hidden post", author, onComment, true)
+	exec("UPDATE posts SET hidden = 0, publish_at = ? WHERE id = ?", getCurrentMilli()+60000, post.Id)
+	check("comment of scheduled post", reader, onComment, false)
+	exec("UPDATE posts SET publish_at = 0 WHERE id = ?", post.Id)
+	exec("UPDATE comments SET hidden = 1 WHERE id = ?", comment.Id)
+	check("comment hidden", reader, onComment, false)
+
+	exec("UPDATE messages SET hidden = 1 WHERE id = ?", message.Id)
+	check("message hidden", recipient, onMessage, false)
+	exec("UPDATE messages SET hidden = 0, publish_at = ? WHERE id = ?", getCurrentMilli()+60000, message.Id)
+	check("message scheduled", recipient, onMessage, false)
+}
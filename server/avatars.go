@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// Avatars are stored and served in these sizes, in pixels
+var avatarSizes = []int{32, 64, 128, 256}
+
+// Size served when size is not given
+const DEFAULT_AVATAR_SIZE = 64
+
+// Version of avatar URL for users without uploaded avatar
+const IDENTICON_VERSION = "identicon"
+
+// Avatar URL changes with content, so it can be cached forever
+func avatarUrl(userId int, avatar string) string {
+	if avatar == "" {
+		avatar = IDENTICON_VERSION
+	}
+	return fmt.Sprintf("/avatar?user_id=%v&v=%v", userId, avatar)
+}
+
+func avatarKey(userId int, avatar string, size int) string {
+	return fmt.Sprintf("avatars/%v-%v-%v.png", userId, avatar, size)
+}
+
+// Smallest stored size that is at least size
+func avatarSize(size int) int {
+	for _, s := range avatarSizes {
+		if s >= size {
+			return s
+		}
+	}
+	return avatarSizes[len(avatarSizes)-1]
+}
+
+// Crop image to centered square, store it in all avatar sizes and
+// return content hash identifying the new avatar
+func saveAvatar(userId int, img image.Image) (string, error) {
+	square := cropSquare(img)
+	images := map[int][]byte{}
+	for _, size := range avatarSizes {
+		var buf bytes.Buffer
+		err := png.Encode(&buf, scaleImage(square, size, size))
+		if err != nil {
+			return "", err
+		}
+		images[size] = buf.Bytes()
+	}
+	sum := sha256.Sum256(images[avatarSizes[len(avatarSizes)-1]])
+	avatar := hex.EncodeToString(sum[:8])
+
+	for _, size := range avatarSizes {
+		err := storage.Put(avatarKey(userId, avatar, size), images[size], "image/png")
+		if err != nil {
+			removeAvatarFiles(userId, avatar)
+			return "", err
+		}
+	}
+	return avatar, nil
+}
+
+func removeAvatarFiles(userId int, avatar string) {
+	if avatar == "" {
+		return
+	}
+	for _, size := range avatarSizes {
+		err := storage.Delete(avatarKey(userId, avatar, size))
+		if err != nil {
+			errorHandler(err)
+		}
+	}
+}
+
+// Symmetric 5x5 pattern in one color, derived from user id
+func identicon(userId int, size int) image.Image {
+	sum := sha256.Sum256([]byte("identicon:" + strconv.Itoa(userId)))
+	fg := color.RGBA{64 + sum[0]%160, 64 + sum[1]%160, 64 + sum[2]%160, 255}
+	bg := color.RGBA{240, 240, 240, 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+
+	// Grid of 5 cells with half a cell of margin on each side
+	cell := size / 6
+	margin := (size - cell*5) / 2
+	for row := 0; row < 5; row++ {
+		for col := 0; col < 3; col++ {
+			bit := row*3 + col
+			if sum[3+bit/8]>>(bit%8)&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, 4 - col} {
+				rect := image.Rect(margin+c*cell, margin+row*cell, margin+(c+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, rect, &image.Uniform{fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return img
+}
+
+// Avatar of user_id in size closest to size. Avatars are public, so that
+// browsers and proxies can cache them. Response for current version v
+// is cached forever, other versions are revalidated
+func avatarHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	size := DEFAULT_AVATAR_SIZE
+	if r.FormValue("size") != "" {
+		size, err = strconv.Atoi(r.FormValue("size"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+	size = avatarSize(size)
+
+	avatar, found, err := getAvatar(userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var data []byte
+	version := avatar
+	if avatar == "" {
+		version = IDENTICON_VERSION
+		var buf bytes.Buffer
+		err = png.Encode(&buf, identicon(userId, size))
+		data = buf.Bytes()
+	} else {
+		var file io.ReadCloser
+		file, err = storage.Get(avatarKey(userId, avatar, size))
+		if err == nil {
+			data, err = ioutil.ReadAll(file)
+			file.Close()
+		}
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_READING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if r.FormValue("v") == version {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Write(data)
+}
+
+// Replace avatar of signed in user with image in form field avatar.
+// Body size is limited before session is checked, so it does not use requireUser
+func uploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, int64(config.AvatarMaxSize+MAX_FORM_SIZE))
+	err := r.ParseMultipartForm(MAX_FORM_SIZE)
+	if err != nil {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, err := getUserBySessionId(r.FormValue("session_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if user == nil {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		resp.Error = &Error{Type: MISSING_PARAM, Message: "Error: missing request parameter: avatar"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(file, int64(config.AvatarMaxSize)+1))
+	file.Close()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_READING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if len(data) > config.AvatarMaxSize {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: avatar is too large"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !isImageType(mimeType) {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: avatar has to be JPEG, PNG or GIF image"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	img, err := decodeImage(data, mimeType)
+	if err != nil {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: avatar is not a valid image"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	avatar, err := saveAvatar(user.Id, img)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_STORING_FILE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	err = updateAvatar(user.Id, avatar)
+	if err != nil {
+		removeAvatarFiles(user.Id, avatar)
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	// Same image uploaded again has the same hash and files
+	if user.Avatar != avatar {
+		removeAvatarFiles(user.Id, user.Avatar)
+	}
+
+	resp.Payload = avatarUrl(user.Id, avatar)
+	json.NewEncoder(w).Encode(resp)
+	broadcastClientsStatus()
+}
+
+// Go back to identicon
+func removeAvatarHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := updateAvatar(user.Id, "")
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	removeAvatarFiles(user.Id, user.Avatar)
+
+	resp.Payload = avatarUrl(user.Id, "")
+	json.NewEncoder(w).Encode(resp)
+	broadcastClientsStatus()
+}
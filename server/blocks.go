@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Sender cannot message user who blocked them or whom they blocked.
+// Message to user accepting only contacts, who has not chatted with sender
+// and does not follow sender, is stored as message request
+func checkCanMessage(sender *User, recipientId int) (bool, *Error) {
+	blocked, err := isBlocked(recipientId, sender.Id)
+	if err != nil {
+		return false, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if blocked {
+		return false, &Error{Type: USER_BLOCKED, Message: "Error: you cannot message this user"}
+	}
+	blocked, err = isBlocked(sender.Id, recipientId)
+	if err != nil {
+		return false, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if blocked {
+		return false, &Error{Type: USER_BLOCKED, Message: "Error: unblock this user to send messages"}
+	}
+
+	privacy, err := getPrivacy(recipientId)
+	if err != nil {
+		return false, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if !privacy.ContactsOnly {
+		return false, nil
+	}
+	contact, err := isContact(recipientId, sender.Id)
+	if err == nil && !contact {
+		contact, err = isFollowing(recipientId, sender.Id)
+	}
+	if err != nil {
+		return false, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	return !contact, nil
+}
+
+// Block user_id. Pending message requests from blocked user are dropped, follows both ways are removed
+func blockHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if userId == user.Id {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: you cannot block yourself"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	target, err := getUserById(userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if target == nil {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = insertBlock(user.Id, target.Id)
+	if err == nil {
+		err = deleteMessageRequest(user.Id, target.Id)
+	}
+	// Blocked user stops following and being followed
+	if err == nil {
+		err = deleteFollow(user.Id, target.Id)
+	}
+	if err == nil {
+		err = deleteFollow(target.Id, user.Id)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	broadcastClientsStatus()
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func unblockHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = deleteBlock(user.Id, userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	broadcastClientsStatus()
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Users blocked by signed in user
+func blocksHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	users, err := getBlockedUsers(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = users
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Pending message requests of signed in user
+func messageRequestsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	requests, err := getMessageRequests(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = requests
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Accept or decline message request from user_id.
+// Accepted messages move into chat, declined ones are deleted
+func messageRequestHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	fromId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "accept":
+		err = acceptMessageRequest(user.Id, fromId)
+	case "decline":
+		err = deleteMessageRequest(user.Id, fromId)
+	default:
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: action should be accept or decline"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	broadcastClientsStatus()
+
+	json.NewEncoder(w).Encode(resp)
+}
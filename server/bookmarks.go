@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+func validateBookmarkCollection(name string) *Error {
+	if len(name) < 1 || len(name) > 50 {
+		return &Error{Type: INVALID_INPUT, Message: "Error: collection name should be between 1 and 50 characters long"}
+	}
+	return nil
+}
+
+// Convert error returned by insertBookmarkCollection/renameBookmarkCollection into response error
+func bookmarkCollectionSaveError(err error) *Error {
+	if strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+		return &Error{Type: INVALID_INPUT, Message: "Error: you already have collection with this name"}
+	}
+	return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+}
+
+// Content type and id of bookmarked content in request. Content type defaults to post
+func bookmarkContentFromForm(r *http.Request) (string, int, *Error) {
+	contentType := r.FormValue("content_type")
+	if contentType == "" {
+		contentType = CONTENT_POST
+	}
+	if contentType != CONTENT_POST && contentType != CONTENT_COMMENT {
+		return "", 0, &Error{Type: INVALID_INPUT, Message: "Error: content_type should be post or comment"}
+	}
+	contentId, err := strconv.Atoi(r.FormValue("content_id"))
+	if err != nil {
+		return "", 0, &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	return contentType, contentId, nil
+}
+
+// Bookmark post or comment content_id, in collection_id if given.
+// Bookmarking again moves bookmark to another collection
+func bookmarkHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	contentType, contentId, e := bookmarkContentFromForm(r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	collectionId := 0
+	if r.FormValue("collection_id") != "" {
+		var err error
+		collectionId, err = strconv.Atoi(r.FormValue("collection_id"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	postId := contentId
+	if contentType == CONTENT_COMMENT {
+		comment, err := getComment(contentId)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		postId = 0
+		if comment != nil {
+			postId = comment.PostId
+		}
+	}
+	post, err := getPost(postId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if post.Id == 0 {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: content not found"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if collectionId != 0 {
+		found, err := isBookmarkCollection(user.Id, collectionId)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if !found {
+			resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: no such collection"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	err = saveBookmark(user.Id, contentType, contentId, collectionId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func unbookmarkHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	contentType, contentId, e := bookmarkContentFromForm(r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := deleteBookmark(user.Id, contentType, contentId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Bookmarks of signed in user with bookmarked content, page by page.
+// Only bookmarks in collection_id if it is given, 0 meaning bookmarks outside of collections
+func bookmarksHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	page := 1
+	if r.FormValue("page") != "" {
+		p, err := strconv.Atoi(r.FormValue("page"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if p > 0 {
+			page = p
+		}
+	}
+	collectionId := -1
+	if r.FormValue("collection_id") != "" {
+		c, err := strconv.Atoi(r.FormValue("collection_id"))
+		if err != nil || c < 0 {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: "Error: invalid collection_id"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		collectionId = c
+	}
+
+	bookmarks, err := getBookmarks(user.Id, collectionId, page)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	for i := range bookmarks {
+		b := &bookmarks[i]
+		if b.ContentType == CONTENT_POST {
+			b.Post, err = getPost(b.ContentId)
+			if err == nil {
+				b.Post.Bookmarked = true
+				err = setPollVoted(b.Post.Poll, b.Post.Id, user.Id)
+			}
+		} else {
+			b.Comment, err = getComment(b.ContentId)
+			if err == nil && b.Comment != nil {
+				b.Comment.Bookmarked = true
+			}
+		}
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	resp.Payload = bookmarks
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Bookmark collections of signed in user
+func bookmarkCollectionsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	collections, err := getBookmarkCollections(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = collections
+	json.NewEncoder(w).Encode(resp)
+}
+
+func addBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	collection := BookmarkCollection{Name: strings.TrimSpace(r.FormValue("name"))}
+	resp.Error = validateBookmarkCollection(collection.Name)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := insertBookmarkCollection(user.Id, &collection)
+	if err != nil {
+		resp.Error = bookmarkCollectionSaveError(err)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = collection
+	json.NewEncoder(w).Encode(resp)
+}
+
+func renameBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	collectionId, err := strconv.Atoi(r.FormValue("collection_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	resp.Error = validateBookmarkCollection(name)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	found, err := renameBookmarkCollection(user.Id, collectionId, name)
+	if err != nil {
+		resp.Error = bookmarkCollectionSaveError(err)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: no such collection"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Remove collection_id. Its bookmarks are kept outside of collections
+func removeBookmarkCollectionHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	collectionId, err := strconv.Atoi(r.FormValue("collection_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	found, err := deleteBookmarkCollection(user.Id, collectionId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: no such collection"}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Administrative commands. They use the same storage and validation
+// functions as http handlers and work on forum.db in current directory
+const cliUsage = `Usage: my_real_time_forum_server [command]
+
+Commands:
+  serve                                       start server (default)
+  user create [-admin] -nick N -email E -first F -last L [-age A] [-gender G] [-password P]
+  user reset-password [-password P] <nick or email>
+  user set-role <nick or email> <admin|moderator|member>
+  user list
+  category add <name>
+  category rename <name> <new name>
+  category remove <name>
+  category list
+  session revoke <nick or email>
+  stats
+
+Password is read from standard input when -password is not given.
+Revoked session cannot be used for requests, websocket already open in running server stays open.
+`
+
+// Runs command and returns process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "user":
+		return userCommand(args[1:])
+	case "category":
+		return categoryCommand(args[1:])
+	case "session":
+		return sessionCommand(args[1:])
+	case "stats":
+		return statsCommand()
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "Error: unknown command %v\n\n%v", args[0], cliUsage)
+	return 2
+}
+
+func userCommand(args []string) int {
+	if len(args) == 0 {
+		return usageError()
+	}
+	switch args[0] {
+	case "create":
+		return userCreateCommand(args[1:])
+	case "reset-password":
+		return userResetPasswordCommand(args[1:])
+	case "set-role":
+		if len(args) != 3 {
+			return usageError()
+		}
+		user, code := findUser(args[1])
+		if user == nil {
+			return code
+		}
+		e := changeRole(user.Id, args[2])
+		if e != nil {
+			return cliError(e)
+		}
+		fmt.Printf("%v is now %v\n", user.NickName, args[2])
+		return 0
+	case "list":
+		users, err := getUsersWithRoles()
+		if err != nil {
+			return cliError(&Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)})
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNICK NAME\tEMAIL\tROLE\tVERIFIED")
+		for _, user := range users {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", user.Id, user.NickName, user.Email, user.Role, user.Verified)
+		}
+		tw.Flush()
+		return 0
+	}
+	return usageError()
+}
+
+// Account created from command line is verified and has no session
+func userCreateCommand(args []string) int {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	admin := fs.Bool("admin", false, "give user administrator role")
+	user := User{}
+	fs.StringVar(&(user.NickName), "nick", "", "nick name")
+	fs.StringVar(&(user.Email), "email", "", "email")
+	fs.StringVar(&(user.FirstName), "first", "", "first name")
+	fs.StringVar(&(user.LastName), "last", "", "last name")
+	fs.StringVar(&(user.Gender), "gender", "Prefer Not To Say", "Male, Female, Other or Prefer Not To Say")
+	age_str := fs.String("age", "0", "age")
+	fs.StringVar(&(user.Password), "password", "", "password")
+	if fs.Parse(args) != nil || fs.NArg() != 0 {
+		return usageError()
+	}
+
+	if user.Password == "" {
+		user.Password = readPassword()
+	}
+	user.Password2 = user.Password
+	e := validateInput(&user, strings.TrimSpace(*age_str))
+	if e != nil {
+		return cliError(e)
+	}
+
+	user.Password = encrypt(user.Password)
+	user.Password2 = ""
+	user.Verified = true
+	if *admin {
+		user.Role = ROLE_ADMIN
+	}
+	id, err := saveUser(&user)
+	if err != nil {
+		return cliError(userSaveError(err))
+	}
+	fmt.Printf("Created %v %v with id %v\n", user.Role, user.NickName, id)
+	return 0
+}
+
+// New password signs user out
+func userResetPasswordCommand(args []string) int {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	password := fs.String("password", "", "new password")
+	if fs.Parse(args) != nil || fs.NArg() != 1 {
+		return usageError()
+	}
+	user, code := findUser(fs.Arg(0))
+	if user == nil {
+		return code
+	}
+
+	user.Password = *password
+	if user.Password == "" {
+		user.Password = readPassword()
+	}
+	user.Password2 = user.Password
+	e := validatePassword(user)
+	if e != nil {
+		return cliError(e)
+	}
+
+	err := updatePassword(user.Id, encrypt(user.Password))
+	if err == nil {
+		err = resetUserSession(user.Id)
+	}
+	if err != nil {
+		return cliError(&Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)})
+	}
+	fmt.Printf("Password of %v changed\n", user.NickName)
+	return 0
+}
+
+func categoryCommand(args []string) int {
+	if len(args) == 0 {
+		return usageError()
+	}
+	var err error
+	switch {
+	case args[0] == "add" && len(args) == 2:
+		category := strings.TrimSpace(args[1])
+		e := validateCategory(category)
+		if e != nil {
+			return cliError(e)
+		}
+		err = insertCategories([]string{category})
+	case args[0] == "rename" && len(args) == 3:
+		category := strings.TrimSpace(args[2])
+		e := validateCategory(category)
+		if e != nil {
+			return cliError(e)
+		}
+		err = renameCategory(args[1], category)
+	case args[0] == "remove" && len(args) == 2:
+		err = removeCategory(args[1])
+	case args[0] == "list" && len(args) == 1:
+		var categories []string
+		categories, err = getCategories()
+		for _, category := range categories {
+			fmt.Println(category)
+		}
+	default:
+		return usageError()
+	}
+	if err != nil {
+		return cliError(categorySaveError(err))
+	}
+	return 0
+}
+
+func sessionCommand(args []string) int {
+	if len(args) != 2 || args[0] != "revoke" {
+		return usageError()
+	}
+	user, code := findUser(args[1])
+	if user == nil {
+		return code
+	}
+	err := resetUserSession(user.Id)
+	if err != nil {
+		return cliError(&Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)})
+	}
+	fmt.Printf("Session of %v revoked\n", user.NickName)
+	return 0
+}
+
+func statsCommand() int {
+	stats, err := getStats()
+	if err != nil {
+		return cliError(&Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)})
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Users\t%v\n", stats.Users)
+	fmt.Fprintf(tw, "Administrators\t%v\n", stats.Admins)
+	fmt.Fprintf(tw, "Moderators\t%v\n", stats.Moderators)
+	fmt.Fprintf(tw, "Unverified\t%v\n", stats.Unverified)
+	fmt.Fprintf(tw, "Signed in\t%v\n", stats.Sessions)
+	fmt.Fprintf(tw, "Posts\t%v\n", stats.Posts)
+	fmt.Fprintf(tw, "Comments\t%v\n", stats.Comments)
+	fmt.Fprintf(tw, "Messages\t%v\n", stats.Messages)
+	fmt.Fprintf(tw, "Categories\t%v\n", stats.Categories)
+	fmt.Fprintf(tw, "Open reports\t%v\n", stats.OpenReports)
+	fmt.Fprintf(tw, "Active sanctions\t%v\n", stats.ActiveSanctions)
+	tw.Flush()
+	return 0
+}
+
+// Returns nil and exit code if user cannot be found
+func findUser(name string) (*User, int) {
+	user, err := getUserByEmailOrNickName(name)
+	if err != nil {
+		return nil, cliError(&Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)})
+	}
+	if user == nil {
+		return nil, cliError(&Error{Type: NO_USER_FOUND, Message: "Error: no such user"})
+	}
+	return user, 0
+}
+
+func readPassword() string {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimRight(line, "\r\n")
+}
+
+func cliError(e *Error) int {
+	fmt.Fprintln(os.Stderr, e.Message)
+	return 1
+}
+
+func usageError() int {
+	fmt.Fprint(os.Stderr, cliUsage)
+	return 2
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"os"
+	"strconv"
+)
+
+// Server settings. Every setting can be overridden with environment variable
+type Config struct {
+	BaseUrl string
+
+	// Key used to sign tokens in links. Generated and stored in database if empty
+	SecretKey string
+
+	// Mail. If SmtpHost is empty emails are written to OutboxDir instead of being sent.
+	// OutboxDir is private, it must not be served
+	SmtpHost     string
+	SmtpPort     string
+	SmtpUsername string
+	SmtpPassword string
+	MailFrom     string
+	OutboxDir    string
+
+	// Lifetime of password reset token in minutes
+	PasswordResetTTL int
+
+	// Lifetime of email verification link in hours
+	VerificationTTL int
+	// Minimum number of seconds between two verification emails
+	VerificationResendInterval int
+	// What users with unverified email are allowed to do
+	UnverifiedCanPost    bool
+	UnverifiedCanComment bool
+	UnverifiedCanMessage bool
+
+	// Take client address from X-Forwarded-For. Enable only behind reverse proxy
+	TrustProxy bool
+
+	// Content is hidden automatically once this many users report it. 0 turns it off
+	ReportHideThreshold int
+
+	// OpenID Connect sign in. Disabled if OidcIssuer is empty
+	OidcIssuer       string
+	OidcClientId     string
+	OidcClientSecret string
+	OidcRedirectUrl  string
+	OidcScopes       string
+
+	// Where uploaded files are kept: "local" directory StorageDir or "s3" bucket.
+	// StorageDir is private, files are served only to users allowed to see them
+	Storage    string
+	StorageDir string
+	// S3 compatible storage. Endpoint is e.g. https://s3.eu-west-1.amazonaws.com or http://localhost:9000
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+
+	// Attachments of posts, comments and messages. Sizes are in bytes, types are media types.
+	// AttachmentQuota is total size of files one user may upload
+	AttachmentMaxSize  int
+	AttachmentMaxCount int
+	AttachmentTypes    string
+	AttachmentQuota    int
+
+	// Largest avatar image accepted, in bytes
+	AvatarMaxSize int
+
+	// Fetch previews of links in content. Private addresses are allowed only for testing against local servers
+	LinkPreviews            bool
+	LinkPreviewAllowPrivate bool
+
+	// Drafts not saved for this many days are deleted
+	DraftTTL int
+}
+
+var config = loadConfig()
+
+func loadConfig() Config {
+	return Config{
+		BaseUrl:                    getEnv("BASE_URL", "http://localhost:8080"),
+		SecretKey:                  getEnv("SECRET_KEY", ""),
+		SmtpHost:                   getEnv("SMTP_HOST", ""),
+		SmtpPort:                   getEnv("SMTP_PORT", "587"),
+		SmtpUsername:               getEnv("SMTP_USERNAME", ""),
+		SmtpPassword:               getEnv("SMTP_PASSWORD", ""),
+		MailFrom:                   getEnv("MAIL_FROM", "no-reply@localhost"),
+		OutboxDir:                  getEnv("OUTBOX_DIR", "./outbox"),
+		PasswordResetTTL:           getEnvInt("PASSWORD_RESET_TTL", 60),
+		VerificationTTL:            getEnvInt("VERIFICATION_TTL", 48),
+		VerificationResendInterval: getEnvInt("VERIFICATION_RESEND_INTERVAL", 60),
+		UnverifiedCanPost:          getEnvBool("UNVERIFIED_CAN_POST", false),
+		UnverifiedCanComment:       getEnvBool("UNVERIFIED_CAN_COMMENT", false),
+		UnverifiedCanMessage:       getEnvBool("UNVERIFIED_CAN_MESSAGE", false),
+		TrustProxy:                 getEnvBool("TRUST_PROXY", false),
+		ReportHideThreshold:        getEnvInt("REPORT_HIDE_THRESHOLD", 3),
+		OidcIssuer:                 getEnv("OIDC_ISSUER", ""),
+		OidcClientId:               getEnv("OIDC_CLIENT_ID", ""),
+		OidcClientSecret:           getEnv("OIDC_CLIENT_SECRET", ""),
+		OidcRedirectUrl:            getEnv("OIDC_REDIRECT_URL", ""),
+		OidcScopes:                 getEnv("OIDC_SCOPES", "openid email profile"),
+		Storage:                    getEnv("STORAGE", "local"),
+		StorageDir:                 getEnv("STORAGE_DIR", "./uploads"),
+		S3Endpoint:                 getEnv("S3_ENDPOINT", ""),
+		S3Bucket:                   getEnv("S3_BUCKET", ""),
+		S3Region:                   getEnv("S3_REGION", "us-east-1"),
+		S3AccessKey:                getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:                getEnv("S3_SECRET_KEY", ""),
+		AttachmentMaxSize:          getEnvInt("ATTACHMENT_MAX_SIZE", 10*1024*1024),
+		AttachmentMaxCount:         getEnvInt("ATTACHMENT_MAX_COUNT", 5),
+		AttachmentTypes:            getEnv("ATTACHMENT_TYPES", "image/jpeg,image/png,image/gif,application/pdf,text/plain,application/zip"),
+		AttachmentQuota:            getEnvInt("ATTACHMENT_QUOTA", 100*1024*1024),
+		AvatarMaxSize:              getEnvInt("AVATAR_MAX_SIZE", 5*1024*1024),
+		LinkPreviews:               getEnvBool("LINK_PREVIEWS", true),
+		LinkPreviewAllowPrivate:    getEnvBool("LINK_PREVIEW_ALLOW_PRIVATE", false),
+		DraftTTL:                   getEnvInt("DRAFT_TTL", 30),
+	}
+}
+
+func (c Config) oidcRedirectUrl() string {
+	if c.OidcRedirectUrl != "" {
+		return c.OidcRedirectUrl
+	}
+	return c.BaseUrl + "/oidccallback"
+}
+
+func getEnv(key string, defaultValue string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
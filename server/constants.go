@@ -20,3 +20,23 @@ const WRONG_METHOD = "error_wrong_method"
 
 const MISSING_PARAM = "missing request parameter"
 const INVALID_INPUT = "invalid input"
+
+const INVALID_CURRENT_PASSWORD = "invalid_current_password"
+const INVALID_TOKEN = "invalid_token"
+const EMAIL_NOT_VERIFIED = "email_not_verified"
+const TOO_MANY_REQUESTS = "too_many_requests"
+const ERROR_SENDING_EMAIL = "error_sending_email"
+const TWO_FACTOR_REQUIRED = "two_factor_required"
+const INVALID_TWO_FACTOR_CODE = "invalid_two_factor_code"
+const FORBIDDEN = "forbidden"
+const CONTENT_NOT_FOUND = "content_not_found"
+const ALREADY_REPORTED = "already_reported"
+const USER_BANNED = "user_banned"
+const USER_MUTED = "user_muted"
+const IP_BANNED = "ip_banned"
+const USER_BLOCKED = "user_blocked"
+const ERROR_STORING_FILE = "error_storing_file"
+const STORAGE_QUOTA_EXCEEDED = "storage_quota_exceeded"
+const POST_LOCKED = "post_locked"
+const DRAFT_CONFLICT = "draft_conflict"
+const POLL_CLOSED = "poll_closed"
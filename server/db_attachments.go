@@ -0,0 +1,133 @@
+package main
+
+import "database/sql"
+
+//      _________attachments_______________________________________________________________________________________________________________________
+//     |  id       |  user_id  |  content_type  |  content_id  |  storage_key  |  thumbnail_key  |  file_name  |  mime_type  |  size     |  width    |  height   |  date     |
+//     |  INTEGER  |  INTEGER  |  TEXT          |  INTEGER     |  TEXT         |  TEXT           |  TEXT       |  TEXT       |  INTEGER  |  INTEGER  |  INTEGER  |  INTEGER  |
+//
+// storage_key and thumbnail_key are keys of files in blob storage, thumbnail_key is empty if file is not an image
+
+func crerateAttachmentsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS attachments(id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, content_type TEXT NOT NULL, content_id INTEGER NOT NULL, storage_key TEXT NOT NULL, thumbnail_key TEXT NOT NULL, file_name TEXT NOT NULL, mime_type TEXT NOT NULL, size INTEGER NOT NULL, width INTEGER NOT NULL, height INTEGER NOT NULL, date INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS attachments_content ON attachments(content_type, content_id)")
+	return err
+}
+
+// Sets id of saved attachment
+func insertAttachment(attachment *Attachment) error {
+	result, err := db.Exec("INSERT INTO attachments (user_id, content_type, content_id, storage_key, thumbnail_key, file_name, mime_type, size, width, height, date) VALUES(?,?,?,?,?,?,?,?,?,?,?)",
+		attachment.UserId, attachment.ContentType, attachment.ContentId, attachment.StorageKey, attachment.ThumbnailKey, attachment.FileName, attachment.MimeType, attachment.Size, attachment.Width, attachment.Height, getCurrentMilli())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	attachment.Id = int(id)
+	return nil
+}
+
+const attachmentColumns = "id, user_id, content_type, content_id, storage_key, thumbnail_key, file_name, mime_type, size, width, height"
+
+func scanAttachments(rows *sql.Rows) ([]Attachment, error) {
+	defer rows.Close()
+	attachments := []Attachment{}
+	for rows.Next() {
+		a := Attachment{}
+		err := rows.Scan(&(a.Id), &(a.UserId), &(a.ContentType), &(a.ContentId), &(a.StorageKey), &(a.ThumbnailKey), &(a.FileName), &(a.MimeType), &(a.Size), &(a.Width), &(a.Height))
+		if err != nil {
+			return nil, err
+		}
+		setAttachmentUrls(&a)
+		attachments = append(attachments, a)
+	}
+	err := rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// Attachments of content in upload order
+func getAttachments(contentType string, contentId int) ([]Attachment, error) {
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE content_type = ? AND content_id = ? ORDER BY id", contentType, contentId)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+// Attachments of several contents at once in upload order, mapped to content id.
+// Every content id has entry
+func getAttachmentsOf(contentType string, contentIds []int) (map[int][]Attachment, error) {
+	ids := intArgs(contentIds)
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE content_type = ? AND content_id IN "+inClause(ids)+" ORDER BY id", append([]interface{}{contentType}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil {
+		return nil, err
+	}
+	byContent := map[int][]Attachment{}
+	for _, id := range contentIds {
+		byContent[id] = []Attachment{}
+	}
+	for _, a := range attachments {
+		byContent[a.ContentId] = append(byContent[a.ContentId], a)
+	}
+	return byContent, nil
+}
+
+// Attachments of post and its comments
+func getPostAttachments(postId int) ([]Attachment, error) {
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE (content_type = ? AND content_id = ?) OR (content_type = ? AND content_id IN (SELECT id FROM comments WHERE post_id = ?))", CONTENT_POST, postId, CONTENT_COMMENT, postId)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+// Attachments of pending messages from fromId to userId
+func getMessageRequestAttachments(userId int, fromId int) ([]Attachment, error) {
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE content_type = ? AND content_id IN (SELECT id FROM messages WHERE from_id = ? AND to_id = ? AND pending = 1)", CONTENT_MESSAGE, fromId, userId)
+	if err != nil {
+		return nil, err
+	}
+	return scanAttachments(rows)
+}
+
+// Total size of files uploaded by user
+func getUsedStorage(userId int) (int, error) {
+	var used int
+	err := db.QueryRow("SELECT COALESCE(SUM(size), 0) FROM attachments WHERE user_id = ?", userId).Scan(&used)
+	return used, err
+}
+
+// Returns nil if there is no such attachment
+func getAttachment(id int) (*Attachment, error) {
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := scanAttachments(rows)
+	if err != nil || len(attachments) == 0 {
+		return nil, err
+	}
+	return &attachments[0], nil
+}
+
+func deleteAttachment(id int) error {
+	_, err := db.Exec("DELETE FROM attachments WHERE id = ?", id)
+	return err
+}
@@ -0,0 +1,96 @@
+package main
+
+//      _________blocks____________________________
+//     |  user_id  |  blocked_id  |  date     |
+//     |  INTEGER  |  INTEGER     |  INTEGER  |
+//
+// user_id is the user who blocked blocked_id
+
+func crerateBlocksTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS blocks(user_id INTEGER NOT NULL, blocked_id INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (user_id, blocked_id))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func insertBlock(userId int, blockedId int) error {
+	statement, err := db.Prepare("INSERT OR IGNORE INTO blocks (user_id, blocked_id, date) VALUES(?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(userId, blockedId, getCurrentMilli())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func deleteBlock(userId int, blockedId int) error {
+	_, err := db.Exec("DELETE FROM blocks WHERE user_id = ? AND blocked_id = ?", userId, blockedId)
+	return err
+}
+
+// True if userId has blocked blockedId
+func isBlocked(userId int, blockedId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM blocks WHERE user_id = ? AND blocked_id = ?", userId, blockedId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Users blocked by userId
+func getBlockedUsers(userId int) ([]*User, error) {
+	rows, err := db.Query("SELECT users.id, nick_name FROM blocks INNER JOIN users ON users.id = blocked_id WHERE user_id = ? ORDER BY nick_name COLLATE NOCASE ASC", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := User{}
+		err = rows.Scan(&(user.Id), &(user.NickName))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Ids of users who have blocked userId
+func getBlockerIds(userId int) (map[int]bool, error) {
+	rows, err := db.Query("SELECT user_id FROM blocks WHERE blocked_id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,206 @@
+package main
+
+import "fmt"
+
+//      _________bookmark_collections_____________
+//     |  id       |  user_id  |  name  |  date     |
+//     |  INTEGER  |  INTEGER  |  TEXT  |  INTEGER  |
+//
+//      _________bookmarks____________________________________________________________
+//     |  user_id  |  content_type  |  content_id  |  collection_id  |  date     |
+//     |  INTEGER  |  TEXT          |  INTEGER     |  INTEGER        |  INTEGER  |
+//
+// content_type is post or comment. collection_id is 0 for bookmarks not in any collection
+
+func crerateBookmarkCollectionsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS bookmark_collections(id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, name TEXT NOT NULL, date INTEGER NOT NULL, UNIQUE (user_id, name))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func crerateBookmarksTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS bookmarks(user_id INTEGER NOT NULL, content_type TEXT NOT NULL, content_id INTEGER NOT NULL, collection_id INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (user_id, content_type, content_id))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sets id of saved collection
+func insertBookmarkCollection(userId int, collection *BookmarkCollection) error {
+	result, err := db.Exec("INSERT INTO bookmark_collections (user_id, name, date) VALUES(?,?,?)", userId, collection.Name, getCurrentMilli())
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	collection.Id = int(id)
+	return nil
+}
+
+// Returns false if userId has no such collection
+func renameBookmarkCollection(userId int, collectionId int, name string) (bool, error) {
+	result, err := db.Exec("UPDATE bookmark_collections SET name = ? WHERE id = ? AND user_id = ?", name, collectionId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Bookmarks of removed collection stay, outside of any collection.
+// Returns false if userId has no such collection
+func deleteBookmarkCollection(userId int, collectionId int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	result, err := tx.Exec("DELETE FROM bookmark_collections WHERE id = ? AND user_id = ?", collectionId, userId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		tx.Rollback()
+		return false, err
+	}
+	_, err = tx.Exec("UPDATE bookmarks SET collection_id = 0 WHERE user_id = ? AND collection_id = ?", userId, collectionId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// True if collectionId belongs to userId
+func isBookmarkCollection(userId int, collectionId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM bookmark_collections WHERE id = ? AND user_id = ?", collectionId, userId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Collections of userId in alphabetical order, with number of bookmarks in each
+func getBookmarkCollections(userId int) ([]BookmarkCollection, error) {
+	rows, err := db.Query("SELECT id, name, (SELECT COUNT(*) FROM bookmarks WHERE bookmarks.user_id = bookmark_collections.user_id AND collection_id = bookmark_collections.id) FROM bookmark_collections WHERE user_id = ? ORDER BY name COLLATE NOCASE ASC", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	collections := []BookmarkCollection{}
+	for rows.Next() {
+		collection := BookmarkCollection{}
+		err = rows.Scan(&(collection.Id), &(collection.Name), &(collection.Count))
+		if err != nil {
+			return nil, err
+		}
+		collections = append(collections, collection)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// Bookmark content, or move existing bookmark to collectionId
+func saveBookmark(userId int, contentType string, contentId int, collectionId int) error {
+	_, err := db.Exec(`INSERT INTO bookmarks (user_id, content_type, content_id, collection_id, date) VALUES(?,?,?,?,?)
+	ON CONFLICT(user_id, content_type, content_id) DO UPDATE SET collection_id = excluded.collection_id`,
+		userId, contentType, contentId, collectionId, getCurrentMilli())
+	return err
+}
+
+func deleteBookmark(userId int, contentType string, contentId int) error {
+	_, err := db.Exec("DELETE FROM bookmarks WHERE user_id = ? AND content_type = ? AND content_id = ?", userId, contentType, contentId)
+	return err
+}
+
+// Ids of content of contentType bookmarked by userId
+func getBookmarkedIds(userId int, contentType string) (map[int]bool, error) {
+	rows, err := db.Query("SELECT content_id FROM bookmarks WHERE user_id = ? AND content_type = ?", userId, contentType)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int]bool{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func isBookmarked(userId int, contentType string, contentId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM bookmarks WHERE user_id = ? AND content_type = ? AND content_id = ?", userId, contentType, contentId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Bookmarks of userId in collectionId, or in all collections if collectionId is -1,
+// newest first, 10 per page. Bookmarks of hidden content are left out
+func getBookmarks(userId int, collectionId int, page int) ([]Bookmark, error) {
+	offset := (page - 1) * 10
+
+	query := fmt.Sprintf(`
+	SELECT content_type, content_id, collection_id, date
+	FROM bookmarks
+	WHERE user_id = ? AND (? = -1 OR collection_id = ?) AND (
+		(content_type = ? AND content_id IN (SELECT id FROM posts WHERE hidden = 0 AND publish_at = 0))
+		OR
+		(content_type = ? AND content_id IN (SELECT comments.id FROM comments INNER JOIN posts ON posts.id = post_id WHERE comments.hidden = 0 AND posts.hidden = 0))
+	)
+	ORDER BY date DESC
+	LIMIT 10 OFFSET %v
+	`, offset)
+	rows, err := db.Query(query, userId, collectionId, collectionId, CONTENT_POST, CONTENT_COMMENT)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bookmarks := []Bookmark{}
+	for rows.Next() {
+		var b Bookmark
+		err = rows.Scan(&(b.ContentType), &(b.ContentId), &(b.CollectionId), &(b.Date))
+		if err != nil {
+			return nil, err
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return bookmarks, nil
+}
@@ -1,5 +1,7 @@
 package main
 
+import "encoding/json"
+
 //Categories sample
 //"gereen apple","cucumber","kivi","green grapes","avocado","broccoli","spinach"
 //       __categories__
@@ -51,3 +53,102 @@ func insertCategories(categories []string) error {
 	}
 	return nil
 }
+
+// Rename category in categories table and in every post using it
+func renameCategory(oldName string, newName string) error {
+	return changeCategory(oldName, newName)
+}
+
+// Remove category from categories table and from every post using it
+func removeCategory(name string) error {
+	return changeCategory(name, "")
+}
+
+// Replace category in posts with newName, or drop it if newName is empty
+func changeCategory(oldName string, newName string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if newName == "" {
+		_, err = tx.Exec("DELETE FROM categories WHERE category = ?", oldName)
+	} else {
+		_, err = tx.Exec("UPDATE categories SET category = ? WHERE category = ?", newName, oldName)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Followers of renamed category keep following it
+	if newName != "" {
+		_, err = tx.Exec("UPDATE OR IGNORE category_follows SET category = ? WHERE category = ?", newName, oldName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM category_follows WHERE category = ?", oldName)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rows, err := tx.Query("SELECT id, categories FROM posts")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	updated := map[int]string{}
+	for rows.Next() {
+		var id int
+		var categories string
+		err = rows.Scan(&id, &categories)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		var arr []string
+		if json.Unmarshal([]byte(categories), &arr) != nil {
+			continue
+		}
+		changed := false
+		result := []string{}
+		for _, category := range arr {
+			if category == oldName {
+				changed = true
+				if newName == "" || containsString(result, newName) {
+					continue
+				}
+				category = newName
+			}
+			result = append(result, category)
+		}
+		if changed {
+			b, err := json.Marshal(result)
+			if err != nil {
+				rows.Close()
+				tx.Rollback()
+				return err
+			}
+			updated[id] = string(b)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for id, categories := range updated {
+		_, err = tx.Exec("UPDATE posts SET categories = ? WHERE id = ?", categories, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -1,8 +1,10 @@
 package main
 
-//       ________comments_____________________________________________
-//      |  id       |  date     |  user_id   |  post_id   |  content  |
-//      |  INTEGER  |  INTEGER  |  INTEGER   |  INTEGER   |  TEXT     |
+//       ________comments_____________________________________________________________________
+//      |  id       |  date     |  user_id   |  post_id   |  content  |  hidden   |  parent_id  |
+//      |  INTEGER  |  INTEGER  |  INTEGER   |  INTEGER   |  TEXT     |  INTEGER  |  INTEGER    |
+//
+// parent_id is id of comment this comment replies to, 0 if it is not a reply
 
 // Create comments table
 func crerateCommentsTable() error {
@@ -12,70 +14,186 @@ func crerateCommentsTable() error {
 	}
 	defer statement.Close()
 	statement.Exec()
-	return nil
+	// Hidden by moderators
+	err = addColumn("comments", "hidden", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	return addColumn("comments", "parent_id", "INTEGER NOT NULL DEFAULT 0")
 }
 
-func saveComment(userId int, postId int, comment string) error {
-	statement, err := db.Prepare("INSERT INTO comments (date, user_id, post_id, content) VALUES (?,?,?,?)")
+// Sets id and date of saved comment
+func saveComment(comment *Comment) error {
+	statement, err := db.Prepare("INSERT INTO comments (date, user_id, post_id, content, parent_id) VALUES (?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
-	date := getCurrentMilli()
-	_, err = statement.Exec(date, userId, postId, comment)
+	comment.Date = int(getCurrentMilli())
+	result, err := statement.Exec(comment.Date, comment.UserId, comment.PostId, comment.Content, comment.ParentId)
 	if err != nil {
 		return err
 	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	comment.Id = int(id)
 	return nil
 }
 
 func getComments(postId int) ([]*Comment, error) {
 	comments := []*Comment{}
 	sql := `
-	SELECT comments.id, comments.date, comments.user_id, users.nick_name, comments.post_id, comments.content 
+	SELECT comments.id, comments.date, comments.user_id, users.nick_name, users.avatar, comments.post_id, comments.content, comments.parent_id
 	FROM comments
 	INNER JOIN users
 	ON comments.user_id = users.id	
-	WHERE post_id = ?
+	WHERE post_id = ? AND comments.hidden = 0
 	ORDER BY comments.date DESC	
 	`
 	rows, err := db.Query(sql, postId)
 	if err != nil {
 		return comments, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		comment := Comment{}
-		err = rows.Scan(&(comment.Id), &(comment.Date), &(comment.UserId), &(comment.UserNickName), &(comment.PostId), &(comment.Content))
+		var avatar string
+		err = rows.Scan(&(comment.Id), &(comment.Date), &(comment.UserId), &(comment.UserNickName), &avatar, &(comment.PostId), &(comment.Content), &(comment.ParentId))
 		if err != nil {
 			return comments, err
 		}
+		comment.UserAvatarUrl = avatarUrl(comment.UserId, avatar)
 		comments = append(comments, &comment)
 	}
 	err = rows.Err()
 	if err != nil {
 		return comments, err
 	}
+	rows.Close()
+
+	// Details are read once cursor is closed
+	for _, comment := range comments {
+		comment.ContentHtml = renderMarkdown(comment.Content)
+		comment.Mentions, err = getMentions(CONTENT_COMMENT, comment.Id)
+		if err != nil {
+			return comments, err
+		}
+		comment.Attachments, err = getAttachments(CONTENT_COMMENT, comment.Id)
+		if err != nil {
+			return comments, err
+		}
+		comment.LinkPreviews, err = getLinkPreviews(comment.Content)
+		if err != nil {
+			return comments, err
+		}
+	}
 	return comments, nil
 }
 
 func getNumberOfComments(postId int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ? AND content IS NOT NULL AND hidden = 0", postId).Scan(&count)
+	if err != nil {
+		return -1, err
+	}
+	return count, nil
+}
+
+// Delete comment with its attachments, mentions, bookmarks and notifications.
+// Replies to deleted comment become replies to its parent
+func deleteComment(commentId int) error {
+	attachments, err := getAttachments(CONTENT_COMMENT, commentId)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE comments SET parent_id = COALESCE((SELECT parent_id FROM comments WHERE id = ?), 0) WHERE parent_id = ?", commentId, commentId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM comments WHERE id = ?", commentId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM bookmarks WHERE content_type = ? AND content_id = ?", CONTENT_COMMENT, commentId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM mentions WHERE content_type = ? AND content_id = ?", CONTENT_COMMENT, commentId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM notifications WHERE comment_id = ?", commentId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	removeAttachments(attachments)
+	return nil
+}
 
+// Returns nil if there is no such visible comment
+// True if comment and post it belongs to are shown, post is not hidden or scheduled
+func isCommentVisible(commentId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM comments INNER JOIN posts ON posts.id = comments.post_id WHERE comments.id = ? AND comments.hidden = 0 AND posts.hidden = 0 AND posts.publish_at = 0", commentId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func getComment(commentId int) (*Comment, error) {
 	sql := `
-	SELECT content
+	SELECT comments.id, comments.date, comments.user_id, users.nick_name, users.avatar, comments.post_id, comments.content, comments.parent_id
 	FROM comments
-	WHERE post_id = ? AND content IS NOT NULL
+	INNER JOIN users
+	ON comments.user_id = users.id
+	WHERE comments.id = ? AND comments.hidden = 0
 	`
-	rows, err := db.Query(sql, postId)
+	rows, err := db.Query(sql, commentId)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	counter := 0
+	defer rows.Close()
+	var comment *Comment = nil
 	for rows.Next() {
-		counter++
+		comment = &Comment{}
+		var avatar string
+		err = rows.Scan(&(comment.Id), &(comment.Date), &(comment.UserId), &(comment.UserNickName), &avatar, &(comment.PostId), &(comment.Content), &(comment.ParentId))
+		if err != nil {
+			return nil, err
+		}
+		comment.UserAvatarUrl = avatarUrl(comment.UserId, avatar)
+		comment.ContentHtml = renderMarkdown(comment.Content)
 	}
-	if rows.Err() != nil {
-		return -1, err
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	if comment != nil {
+		comment.Attachments, err = getAttachments(CONTENT_COMMENT, comment.Id)
+		if err != nil {
+			return nil, err
+		}
+		comment.LinkPreviews, err = getLinkPreviews(comment.Content)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return counter, nil
+	return comment, nil
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+)
+
+//      _________drafts______________________________________________________________________________________
+//     |  user_id  |  target  |  title  |  content  |  categories  |  version  |  date     |
+//     |  INTEGER  |  TEXT    |  TEXT   |  TEXT     |  TEXT        |  INTEGER  |  INTEGER  |
+//
+// target is "post" for new post, "comment:<post_id>" or "message:<user_id>".
+// version grows by one with every save, date is time of last save
+
+func crerateDraftsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS drafts(user_id INTEGER NOT NULL, target TEXT NOT NULL, title TEXT NOT NULL, content TEXT NOT NULL, categories TEXT NOT NULL, version INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (user_id, target))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Save draft of userId. If version is -1 draft is overwritten, otherwise it is saved only
+// if stored draft has that version, 0 meaning there is no stored draft.
+// Returns false if draft was not saved because of version
+func saveDraft(userId int, draft *Draft, version int) (bool, error) {
+	categories, err := json.Marshal(draft.Categories)
+	if err != nil {
+		categories = []byte("[]")
+	}
+	date := getCurrentMilli()
+
+	var result sql.Result
+	switch {
+	case version == -1:
+		result, err = db.Exec(`INSERT INTO drafts (user_id, target, title, content, categories, version, date) VALUES(?,?,?,?,?,1,?)
+		ON CONFLICT(user_id, target) DO UPDATE SET title = excluded.title, content = excluded.content, categories = excluded.categories, version = version + 1, date = excluded.date`,
+			userId, draft.Target, draft.Title, draft.Content, string(categories), date)
+	case version == 0:
+		result, err = db.Exec("INSERT OR IGNORE INTO drafts (user_id, target, title, content, categories, version, date) VALUES(?,?,?,?,?,1,?)",
+			userId, draft.Target, draft.Title, draft.Content, string(categories), date)
+	default:
+		result, err = db.Exec("UPDATE drafts SET title = ?, content = ?, categories = ?, version = version + 1, date = ? WHERE user_id = ? AND target = ? AND version = ?",
+			draft.Title, draft.Content, string(categories), date, userId, draft.Target, version)
+	}
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected > 0, nil
+}
+
+const draftColumns = "target, title, content, categories, version, date"
+
+func scanDrafts(rows *sql.Rows) ([]Draft, error) {
+	defer rows.Close()
+	drafts := []Draft{}
+	for rows.Next() {
+		draft := Draft{}
+		var categories string
+		err := rows.Scan(&(draft.Target), &(draft.Title), &(draft.Content), &categories, &(draft.Version), &(draft.Date))
+		if err != nil {
+			return nil, err
+		}
+		err = json.Unmarshal([]byte(categories), &(draft.Categories))
+		if err != nil || draft.Categories == nil {
+			draft.Categories = []string{}
+		}
+		drafts = append(drafts, draft)
+	}
+	err := rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return drafts, nil
+}
+
+// Drafts of userId saved after since, last saved first
+func getDrafts(userId int, since int64) ([]Draft, error) {
+	rows, err := db.Query("SELECT "+draftColumns+" FROM drafts WHERE user_id = ? AND date > ? ORDER BY date DESC", userId, since)
+	if err != nil {
+		return nil, err
+	}
+	return scanDrafts(rows)
+}
+
+// Returns nil if userId has no draft for target saved after since
+func getDraft(userId int, target string, since int64) (*Draft, error) {
+	rows, err := db.Query("SELECT "+draftColumns+" FROM drafts WHERE user_id = ? AND target = ? AND date > ?", userId, target, since)
+	if err != nil {
+		return nil, err
+	}
+	drafts, err := scanDrafts(rows)
+	if err != nil || len(drafts) == 0 {
+		return nil, err
+	}
+	return &drafts[0], nil
+}
+
+func deleteDraft(userId int, target string) error {
+	_, err := db.Exec("DELETE FROM drafts WHERE user_id = ? AND target = ?", userId, target)
+	return err
+}
+
+// Delete drafts last saved before date
+func deleteDraftsBefore(date int64) error {
+	_, err := db.Exec("DELETE FROM drafts WHERE date <= ?", date)
+	return err
+}
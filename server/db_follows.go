@@ -0,0 +1,151 @@
+package main
+
+//      _________follows______________________________
+//     |  user_id  |  followed_id  |  date     |
+//     |  INTEGER  |  INTEGER      |  INTEGER  |
+//
+//      _________category_follows_________________
+//     |  user_id  |  category  |  date     |
+//     |  INTEGER  |  TEXT      |  INTEGER  |
+//
+//      _________follow_notifications_________________
+//     |  user_id  |  followed_id  |  date     |
+//     |  INTEGER  |  INTEGER      |  INTEGER  |
+//
+// user_id is the follower. follow_notifications is kept after unfollow,
+// so that followed user is notified only the first time
+
+func crerateFollowsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS follows(user_id INTEGER NOT NULL, followed_id INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (user_id, followed_id))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS follows_followed ON follows(followed_id)")
+	return err
+}
+
+func crerateCategoryFollowsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS category_follows(user_id INTEGER NOT NULL, category TEXT NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (user_id, category))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func crerateFollowNotificationsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS follow_notifications(user_id INTEGER NOT NULL, followed_id INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (user_id, followed_id))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	return err
+}
+
+// Returns false if userId already follows followedId
+func insertFollow(userId int, followedId int) (bool, error) {
+	result, err := db.Exec("INSERT OR IGNORE INTO follows (user_id, followed_id, date) VALUES(?,?,?)", userId, followedId, getCurrentMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Returns false if followedId was already notified about userId following
+func insertFollowNotification(userId int, followedId int) (bool, error) {
+	result, err := db.Exec("INSERT OR IGNORE INTO follow_notifications (user_id, followed_id, date) VALUES(?,?,?)", userId, followedId, getCurrentMilli())
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+func deleteFollow(userId int, followedId int) error {
+	_, err := db.Exec("DELETE FROM follows WHERE user_id = ? AND followed_id = ?", userId, followedId)
+	return err
+}
+
+// True if userId follows followedId
+func isFollowing(userId int, followedId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM follows WHERE user_id = ? AND followed_id = ?", userId, followedId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Users followed by userId if followers is false, users following userId otherwise
+func getFollowUsers(userId int, followers bool) ([]*User, error) {
+	sql := "SELECT users.id, nick_name, avatar FROM follows INNER JOIN users ON users.id = followed_id WHERE user_id = ? ORDER BY nick_name COLLATE NOCASE ASC"
+	if followers {
+		sql = "SELECT users.id, nick_name, avatar FROM follows INNER JOIN users ON users.id = user_id WHERE followed_id = ? ORDER BY nick_name COLLATE NOCASE ASC"
+	}
+	rows, err := db.Query(sql, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := User{}
+		err = rows.Scan(&(user.Id), &(user.NickName), &(user.Avatar))
+		if err != nil {
+			return nil, err
+		}
+		user.AvatarUrl = avatarUrl(user.Id, user.Avatar)
+		users = append(users, &user)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func insertCategoryFollow(userId int, category string) error {
+	_, err := db.Exec("INSERT OR IGNORE INTO category_follows (user_id, category, date) VALUES(?,?,?)", userId, category, getCurrentMilli())
+	return err
+}
+
+func deleteCategoryFollow(userId int, category string) error {
+	_, err := db.Exec("DELETE FROM category_follows WHERE user_id = ? AND category = ?", userId, category)
+	return err
+}
+
+// Categories followed by userId in alphabetical order
+func getFollowedCategories(userId int) ([]string, error) {
+	rows, err := db.Query("SELECT category FROM category_follows WHERE user_id = ? ORDER BY category COLLATE NOCASE ASC", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []string{}
+	for rows.Next() {
+		var category string
+		err = rows.Scan(&category)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+//      _________identities_______________________
+//     |  issuer  |  subject  |  user_id  |  date     |
+//     |  TEXT    |  TEXT     |  INTEGER  |  INTEGER  |
+//
+// Accounts at external identity providers linked to forum users
+
+func crerateIdentitiesTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS identities(issuer TEXT NOT NULL, subject TEXT NOT NULL, user_id INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (issuer, subject))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns -1 if identity is not linked to any user
+func getIdentityUserId(issuer string, subject string) (int, error) {
+	rows, err := db.Query("SELECT user_id FROM identities WHERE issuer = ? AND subject = ?", issuer, subject)
+	if err != nil {
+		return -1, err
+	}
+	defer rows.Close()
+	userId := -1
+	for rows.Next() {
+		err = rows.Scan(&userId)
+		if err != nil {
+			return -1, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return -1, err
+	}
+	return userId, nil
+}
+
+func insertIdentity(issuer string, subject string, userId int) error {
+	statement, err := db.Prepare("INSERT INTO identities (issuer, subject, user_id, date) VALUES(?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(issuer, subject, userId, getCurrentMilli())
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import "database/sql"
+
+//      _________link_previews______________________________________________________________
+//     |  url   |  title  |  description  |  image  |  site_name  |  date     |
+//     |  TEXT  |  TEXT   |  TEXT         |  TEXT   |  TEXT       |  INTEGER  |
+//
+// Cache of fetched previews. Empty title means page had no usable metadata or could not be fetched
+
+func crerateLinkPreviewsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS link_previews(url TEXT PRIMARY KEY, title TEXT NOT NULL, description TEXT NOT NULL, image TEXT NOT NULL, site_name TEXT NOT NULL, date INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	return err
+}
+
+func saveLinkPreview(preview *LinkPreview) error {
+	_, err := db.Exec("INSERT OR REPLACE INTO link_previews (url, title, description, image, site_name, date) VALUES(?,?,?,?,?,?)", preview.Url, preview.Title, preview.Description, preview.Image, preview.SiteName, getCurrentMilli())
+	return err
+}
+
+// Cached preview of url and time it was fetched, nil if url has not been fetched
+func getLinkPreview(url string) (*LinkPreview, int64, error) {
+	preview := LinkPreview{}
+	var date int64
+	err := db.QueryRow("SELECT url, title, description, image, site_name, date FROM link_previews WHERE url = ?", url).Scan(&(preview.Url), &(preview.Title), &(preview.Description), &(preview.Image), &(preview.SiteName), &date)
+	if err == sql.ErrNoRows {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return &preview, date, nil
+}
+
+// Cached previews of several urls at once, mapped to url. Urls not fetched yet have no entry
+func getLinkPreviewsByUrl(urls []string) (map[string]LinkPreview, error) {
+	args := make([]interface{}, len(urls))
+	for i, url := range urls {
+		args[i] = url
+	}
+	rows, err := db.Query("SELECT url, title, description, image, site_name FROM link_previews WHERE url IN "+inClause(args), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	previews := map[string]LinkPreview{}
+	for rows.Next() {
+		preview := LinkPreview{}
+		err = rows.Scan(&(preview.Url), &(preview.Title), &(preview.Description), &(preview.Image), &(preview.SiteName))
+		if err != nil {
+			return nil, err
+		}
+		previews[preview.Url] = preview
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return previews, nil
+}
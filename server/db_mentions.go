@@ -0,0 +1,80 @@
+package main
+
+//      _________mentions__________________________________________________________
+//     |  content_type  |  content_id  |  user_id  |  offset   |  length   |
+//     |  TEXT          |  INTEGER     |  INTEGER  |  INTEGER  |  INTEGER  |
+//
+// offset and length of @nick_name in content, in UTF-16 code units as used by JavaScript
+
+func crerateMentionsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS mentions(content_type TEXT NOT NULL, content_id INTEGER NOT NULL, user_id INTEGER NOT NULL, offset INTEGER NOT NULL, length INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS mentions_content ON mentions(content_type, content_id)")
+	return err
+}
+
+func insertMentions(contentType string, contentId int, mentions []Mention) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, mention := range mentions {
+		_, err = tx.Exec("INSERT INTO mentions (content_type, content_id, user_id, offset, length) VALUES(?,?,?,?,?)", contentType, contentId, mention.UserId, mention.Offset, mention.Length)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Mentions in content ordered by position. Nick name is current nick name of user
+func getMentions(contentType string, contentId int) ([]Mention, error) {
+	mentions, err := getMentionsOf(contentType, []int{contentId})
+	if err != nil {
+		return nil, err
+	}
+	return mentions[contentId], nil
+}
+
+// Mentions of several contents at once, mapped to content id. Every content id has entry
+func getMentionsOf(contentType string, contentIds []int) (map[int][]Mention, error) {
+	ids := intArgs(contentIds)
+	sql := "SELECT content_id, user_id, users.nick_name, offset, length FROM mentions INNER JOIN users ON users.id = user_id WHERE content_type = ? AND content_id IN " + inClause(ids) + " ORDER BY offset"
+	rows, err := db.Query(sql, append([]interface{}{contentType}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := map[int][]Mention{}
+	for _, id := range contentIds {
+		mentions[id] = []Mention{}
+	}
+	for rows.Next() {
+		var contentId int
+		var mention Mention
+		err = rows.Scan(&contentId, &(mention.UserId), &(mention.NickName), &(mention.Offset), &(mention.Length))
+		if err != nil {
+			return nil, err
+		}
+		mentions[contentId] = append(mentions[contentId], mention)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+func deleteMentions(contentType string, contentId int) error {
+	_, err := db.Exec("DELETE FROM mentions WHERE content_type = ? AND content_id = ?", contentType, contentId)
+	return err
+}
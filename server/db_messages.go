@@ -2,9 +2,12 @@ package main
 
 import "fmt"
 
-//      _________messages__________________________________________
-//     |  id       |  from_id  |  to_id    |  content  |  date     |
-//     |  INTEGER  |  INTEGER  |  INTEGER  |  TEXT     |  INTEGER  |
+//      _________messages_________________________________________________________________________________
+//     |  id       |  from_id  |  to_id    |  content  |  date     |  hidden   |  pending  |  publish_at  |
+//     |  INTEGER  |  INTEGER  |  INTEGER  |  TEXT     |  INTEGER  |  INTEGER  |  INTEGER  |  INTEGER     |
+//
+// pending is 1 for message requests not yet accepted by recipient.
+// publish_at is time scheduled message gets sent, 0 once it is sent
 
 func crerateMessagesTable() error {
 	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS messages(id INTEGER PRIMARY KEY, from_id INTEGER NOT NULL, to_id INTEGER NOT NULL, content TEXT NOT NULL, date INTEGER NOT NULL)")
@@ -16,20 +19,35 @@ func crerateMessagesTable() error {
 	if err != nil {
 		return err
 	}
-	return nil
+	// Hidden by moderators
+	err = addColumn("messages", "hidden", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumn("messages", "pending", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	return addColumn("messages", "publish_at", "INTEGER NOT NULL DEFAULT 0")
 }
 
-func insertMessage(message Message) error {
-	statement, err := db.Prepare("INSERT INTO messages (from_id, to_id, content, date) VALUES(?,?,?,?)")
+// Sets id of saved message
+func insertMessage(message *Message) error {
+	statement, err := db.Prepare("INSERT INTO messages (from_id, to_id, content, date, pending, publish_at) VALUES(?,?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
 	defer statement.Close()
 
-	_, err = statement.Exec(message.FromId, message.ToId, message.Content, message.Date)
+	result, err := statement.Exec(message.FromId, message.ToId, message.Content, message.Date, message.Pending, message.PublishAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
 	if err != nil {
 		return err
 	}
+	message.Id = int(id)
 	return nil
 }
 
@@ -40,16 +58,16 @@ func getChat(from_id int, to_id int, page int) (*[]Message, error) {
 	query := fmt.Sprintf(
 		`
 	SELECT
-	messages.id, from_id, users.nick_name, to_id, content, date
+	messages.id, from_id, users.nick_name, to_id, content, messages.date AS date, pending
 	FROM messages
 	INNER JOIN users ON users.id = from_id
-	WHERE from_id = ? AND to_id = ?
+	WHERE from_id = ? AND to_id = ? AND hidden = 0 AND publish_at = 0
 	UNION
 	SELECT
-	messages.id, from_id, users.nick_name, to_id, content, date
+	messages.id, from_id, users.nick_name, to_id, content, messages.date AS date, pending
 	FROM messages
 	INNER JOIN users ON users.id = from_id
-	WHERE from_id = ? AND to_id = ?	
+	WHERE from_id = ? AND to_id = ? AND hidden = 0 AND pending = 0 AND publish_at = 0
 	ORDER BY date DESC
 
 	LIMIT 10 OFFSET %v 
@@ -65,7 +83,20 @@ func getChat(from_id int, to_id int, page int) (*[]Message, error) {
 
 	for rows.Next() {
 		var message Message
-		err = rows.Scan(&(message.Id), &(message.FromId), &(message.FromNickName), &(message.ToId), &(message.Content), &(message.Date))
+		err = rows.Scan(&(message.Id), &(message.FromId), &(message.FromNickName), &(message.ToId), &(message.Content), &(message.Date), &(message.Pending))
+		if err != nil {
+			return nil, err
+		}
+		message.ContentHtml = renderMarkdown(message.Content)
+		message.Mentions, err = getMentions(CONTENT_MESSAGE, message.Id)
+		if err != nil {
+			return nil, err
+		}
+		message.Attachments, err = getAttachments(CONTENT_MESSAGE, message.Id)
+		if err != nil {
+			return nil, err
+		}
+		message.LinkPreviews, err = getLinkPreviews(message.Content)
 		if err != nil {
 			return nil, err
 		}
@@ -104,7 +135,7 @@ func getChatMates(id int) ([]*User, error) {
 	//Both
 	query :=
 		`
-		SELECT users.id, nick_name
+		SELECT users.id, nick_name, avatar
 		FROM users
 		INNER JOIN 
 		(
@@ -115,12 +146,12 @@ func getChatMates(id int) ([]*User, error) {
 		(
 		SELECT MAX(date) AS date, from_id AS u_id
 		FROM messages
-		WHERE to_id = ?
+		WHERE to_id = ? AND pending = 0 AND publish_at = 0
 		GROUP BY u_id
 		UNION ALL
 		SELECT MAX(date) As date, to_id As u_id
 		FROM messages
-		WHERE from_id = ?
+		WHERE from_id = ? AND publish_at = 0
 		GROUP BY u_id		
 		)
 		GROUP BY u_id
@@ -145,11 +176,12 @@ func getChatMates(id int) ([]*User, error) {
 		//var maxDate int
 		var user User
 		//err = rows.Scan(&maxDate, &(user.Id))
-		err = rows.Scan(&(user.Id), &(user.NickName))
+		err = rows.Scan(&(user.Id), &(user.NickName), &(user.Avatar))
 		//fmt.Println(user.Id, ": ", user.NickName)
 		if err != nil {
 			return nil, err
 		}
+		user.AvatarUrl = avatarUrl(user.Id, user.Avatar)
 		users = append(users, &user)
 	}
 	err = rows.Err()
@@ -158,3 +190,224 @@ func getChatMates(id int) ([]*User, error) {
 	}
 	return users, nil
 }
+
+func deleteMessage(messageId int) error {
+	attachments, err := getAttachments(CONTENT_MESSAGE, messageId)
+	if err != nil {
+		return err
+	}
+	statement, err := db.Prepare("DELETE FROM messages WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(messageId)
+	if err != nil {
+		return err
+	}
+	removeAttachments(attachments)
+	return deleteMentions(CONTENT_MESSAGE, messageId)
+}
+
+// Returns nil if there is no such message
+func getMessage(messageId int) (*Message, error) {
+	rows, err := db.Query("SELECT messages.id, from_id, users.nick_name, to_id, content, messages.date, pending, publish_at FROM messages INNER JOIN users ON users.id = from_id WHERE messages.id = ?", messageId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var message *Message = nil
+	for rows.Next() {
+		message = &Message{}
+		err = rows.Scan(&(message.Id), &(message.FromId), &(message.FromNickName), &(message.ToId), &(message.Content), &(message.Date), &(message.Pending), &(message.PublishAt))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// True if message is sent, not hidden and userId is its sender or recipient
+func isMessageVisible(messageId int, userId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE id = ? AND hidden = 0 AND publish_at = 0 AND (from_id = ? OR to_id = ?)", messageId, userId, userId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// True if recipient has written to sender or accepted sender's messages before
+func isContact(recipientId int, senderId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM messages WHERE publish_at = 0 AND ((from_id = ? AND to_id = ?) OR (from_id = ? AND to_id = ? AND pending = 0))", recipientId, senderId, senderId, recipientId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Pending messages grouped by sender, latest request first
+func getMessageRequests(userId int) ([]MessageRequest, error) {
+	query := `
+	SELECT from_id, users.nick_name, COUNT(*), MAX(messages.date), content
+	FROM messages
+	INNER JOIN users ON users.id = from_id
+	WHERE to_id = ? AND pending = 1 AND hidden = 0
+	GROUP BY from_id
+	ORDER BY MAX(messages.date) DESC
+	`
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requests := []MessageRequest{}
+	for rows.Next() {
+		var request MessageRequest
+		err = rows.Scan(&(request.FromId), &(request.FromNickName), &(request.Count), &(request.Date), &(request.Content))
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+// Move pending messages from fromId into chat with userId
+func acceptMessageRequest(userId int, fromId int) error {
+	_, err := db.Exec("UPDATE messages SET pending = 0 WHERE from_id = ? AND to_id = ? AND pending = 1", fromId, userId)
+	return err
+}
+
+func deleteMessageRequest(userId int, fromId int) error {
+	attachments, err := getMessageRequestAttachments(userId, fromId)
+	if err != nil {
+		return err
+	}
+	removeAttachments(attachments)
+	_, err = db.Exec("DELETE FROM mentions WHERE content_type = ? AND content_id IN (SELECT id FROM messages WHERE from_id = ? AND to_id = ? AND pending = 1)", CONTENT_MESSAGE, fromId, userId)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM messages WHERE from_id = ? AND to_id = ? AND pending = 1", fromId, userId)
+	return err
+}
+
+// Scheduled messages of userId, next to be sent first
+func getScheduledMessages(userId int) ([]Message, error) {
+	rows, err := db.Query("SELECT messages.id, from_id, users.nick_name, to_id, content, messages.date, publish_at FROM messages INNER JOIN users ON users.id = from_id WHERE from_id = ? AND publish_at > 0 ORDER BY publish_at", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []Message{}
+	for rows.Next() {
+		message := Message{}
+		err = rows.Scan(&(message.Id), &(message.FromId), &(message.FromNickName), &(message.ToId), &(message.Content), &(message.Date), &(message.PublishAt))
+		if err != nil {
+			return nil, err
+		}
+		message.ContentHtml = renderMarkdown(message.Content)
+		message.Mentions = []Mention{}
+		message.LinkPreviews = []LinkPreview{}
+		message.Attachments, err = getAttachments(CONTENT_MESSAGE, message.Id)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// Ids of scheduled messages due at date
+func getDueMessages(date int64) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM messages WHERE publish_at > 0 AND publish_at <= ?", date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Send scheduled message with date of sending, if it is still due.
+// Returns false if it was rescheduled or canceled meanwhile
+func markMessageSent(messageId int, date int64, pending bool) (bool, error) {
+	result, err := db.Exec("UPDATE messages SET publish_at = 0, date = ?, pending = ? WHERE id = ? AND publish_at > 0 AND publish_at <= ?", date, pending, messageId, date)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Delete scheduled message that cannot be sent and its attachments, whoever wrote it
+func dropScheduledMessage(messageId int) error {
+	attachments, err := getAttachments(CONTENT_MESSAGE, messageId)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM messages WHERE id = ? AND publish_at > 0", messageId)
+	if err != nil {
+		return err
+	}
+	removeAttachments(attachments)
+	return nil
+}
+
+// Returns false if userId has no such scheduled message
+func rescheduleMessage(userId int, messageId int, publishAt int64) (bool, error) {
+	result, err := db.Exec("UPDATE messages SET publish_at = ? WHERE id = ? AND from_id = ? AND publish_at > 0", publishAt, messageId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Delete scheduled message of userId and its attachments.
+// Returns false if userId has no such scheduled message
+func deleteScheduledMessage(userId int, messageId int) (bool, error) {
+	attachments, err := getAttachments(CONTENT_MESSAGE, messageId)
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec("DELETE FROM messages WHERE id = ? AND from_id = ? AND publish_at > 0", messageId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	removeAttachments(attachments)
+	return true, nil
+}
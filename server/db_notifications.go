@@ -0,0 +1,94 @@
+package main
+
+import "fmt"
+
+//      _________notifications____________________________________________________________________________________
+//     |  id       |  user_id  |  type  |  actor_id  |  post_id  |  comment_id  |  content  |  date     |  read     |
+//     |  INTEGER  |  INTEGER  |  TEXT  |  INTEGER   |  INTEGER  |  INTEGER     |  TEXT     |  INTEGER  |  INTEGER  |
+//
+// actor_id is user who caused notification, post_id and comment_id are 0 when not relevant
+
+func crerateNotificationsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS notifications(id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, type TEXT NOT NULL, actor_id INTEGER NOT NULL, post_id INTEGER NOT NULL DEFAULT 0, comment_id INTEGER NOT NULL DEFAULT 0, content TEXT NOT NULL, date INTEGER NOT NULL, read INTEGER NOT NULL DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS notifications_user_id ON notifications(user_id, read)")
+	return err
+}
+
+func insertNotification(notification *Notification) error {
+	statement, err := db.Prepare("INSERT INTO notifications (user_id, type, actor_id, post_id, comment_id, content, date) VALUES(?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	result, err := statement.Exec(notification.UserId, notification.Type, notification.ActorId, notification.PostId, notification.CommentId, notification.Content, notification.Date)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	notification.Id = int(id)
+	return nil
+}
+
+// Notifications of user, newest first, 10 per page
+func getNotifications(userId int, page int) ([]Notification, error) {
+	offset := (page - 1) * 10
+
+	query := fmt.Sprintf(`
+	SELECT notifications.id, user_id, type, actor_id, users.nick_name, post_id, comment_id, content, notifications.date, read
+	FROM notifications
+	INNER JOIN users ON users.id = actor_id
+	WHERE user_id = ?
+	ORDER BY notifications.date DESC, notifications.id DESC
+	LIMIT 10 OFFSET %v
+	`, offset)
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	notifications := []Notification{}
+	for rows.Next() {
+		var n Notification
+		err = rows.Scan(&(n.Id), &(n.UserId), &(n.Type), &(n.ActorId), &(n.ActorNickName), &(n.PostId), &(n.CommentId), &(n.Content), &(n.Date), &(n.Read))
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return notifications, nil
+}
+
+func countUnreadNotifications(userId int) (int, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND read = 0", userId).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func markNotificationRead(userId int, notificationId int) error {
+	_, err := db.Exec("UPDATE notifications SET read = 1 WHERE id = ? AND user_id = ?", notificationId, userId)
+	return err
+}
+
+func markAllNotificationsRead(userId int) error {
+	_, err := db.Exec("UPDATE notifications SET read = 1 WHERE user_id = ? AND read = 0", userId)
+	return err
+}
@@ -0,0 +1,74 @@
+package main
+
+//      _________password_resets___________________________________________
+//     |  id       |  user_id  |  token_hash  |  expires   |  used     |
+//     |  INTEGER  |  INTEGER  |  TEXT        |  INTEGER   |  INTEGER  |
+
+func creratePasswordResetsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS password_resets(id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, token_hash TEXT NOT NULL UNIQUE, expires INTEGER NOT NULL, used INTEGER NOT NULL DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Save new reset token. Previous tokens of the user stop working
+func insertPasswordReset(userId int, tokenHash string, expires int64) error {
+	_, err := db.Exec("UPDATE password_resets SET used = 1 WHERE user_id = ?", userId)
+	if err != nil {
+		return err
+	}
+	statement, err := db.Prepare("INSERT INTO password_resets (user_id, token_hash, expires) VALUES(?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(userId, tokenHash, expires)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Mark token as used and return its user id. Returns -1 if token is unknown, expired or already used
+func usePasswordReset(tokenHash string) (int, error) {
+	rows, err := db.Query("SELECT id, user_id FROM password_resets WHERE token_hash = ? AND used = 0 AND expires > ? LIMIT 1", tokenHash, getCurrentMilli())
+	if err != nil {
+		return -1, err
+	}
+	defer rows.Close()
+	id := -1
+	userId := -1
+	for rows.Next() {
+		err = rows.Scan(&id, &userId)
+		if err != nil {
+			return -1, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return -1, err
+	}
+	if id == -1 {
+		return -1, nil
+	}
+
+	// Only one request can win the token
+	result, err := db.Exec("UPDATE password_resets SET used = 1 WHERE id = ? AND used = 0", id)
+	if err != nil {
+		return -1, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1, err
+	}
+	if n != 1 {
+		return -1, nil
+	}
+	return userId, nil
+}
@@ -0,0 +1,267 @@
+package main
+
+//      _________polls_____________________________________________________
+//     |  post_id  |  question  |  multiple  |  anonymous  |  closes_at  |
+//     |  INTEGER  |  TEXT      |  INTEGER   |  INTEGER    |  INTEGER    |
+//
+//      _________poll_options_______________________
+//     |  id       |  post_id  |  position  |  text  |
+//     |  INTEGER  |  INTEGER  |  INTEGER   |  TEXT  |
+//
+//      _________poll_votes________________________________
+//     |  option_id  |  post_id  |  user_id  |  date     |
+//     |  INTEGER    |  INTEGER  |  INTEGER  |  INTEGER  |
+//
+// Post has at most one poll. closes_at is 0 for polls that stay open.
+// Voters of anonymous polls are stored too, so that votes can be changed, but never shown
+
+func creratePollsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS polls(post_id INTEGER PRIMARY KEY, question TEXT NOT NULL, multiple INTEGER NOT NULL, anonymous INTEGER NOT NULL, closes_at INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func creratePollOptionsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS poll_options(id INTEGER PRIMARY KEY, post_id INTEGER NOT NULL, position INTEGER NOT NULL, text TEXT NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS poll_options_post ON poll_options(post_id)")
+	return err
+}
+
+func creratePollVotesTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS poll_votes(option_id INTEGER NOT NULL, post_id INTEGER NOT NULL, user_id INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (option_id, user_id))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS poll_votes_post ON poll_votes(post_id, user_id)")
+	return err
+}
+
+// Save poll of post with its options. Sets ids of options
+func insertPoll(postId int, poll *Poll) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO polls (post_id, question, multiple, anonymous, closes_at) VALUES(?,?,?,?,?)", postId, poll.Question, poll.Multiple, poll.Anonymous, poll.ClosesAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for i := range poll.Options {
+		result, err := tx.Exec("INSERT INTO poll_options (post_id, position, text) VALUES(?,?,?)", postId, i, poll.Options[i].Text)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		poll.Options[i].Id = int(id)
+	}
+	return tx.Commit()
+}
+
+// Poll of post with current results, nil if post has no poll
+func getPoll(postId int) (*Poll, error) {
+	polls, err := getPolls([]int{postId})
+	if err != nil {
+		return nil, err
+	}
+	return polls[postId], nil
+}
+
+// Polls of several posts at once with current results, mapped to post id.
+// Posts without poll have no entry
+func getPolls(postIds []int) (map[int]*Poll, error) {
+	ids := intArgs(postIds)
+	rows, err := db.Query("SELECT post_id, question, multiple, anonymous, closes_at FROM polls WHERE post_id IN "+inClause(ids), ids...)
+	if err != nil {
+		return nil, err
+	}
+	polls := map[int]*Poll{}
+	withPoll := []int{}
+	for rows.Next() {
+		var postId int
+		poll := &Poll{Options: []PollOption{}}
+		err = rows.Scan(&postId, &(poll.Question), &(poll.Multiple), &(poll.Anonymous), &(poll.ClosesAt))
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		poll.Closed = poll.ClosesAt > 0 && getCurrentMilli() >= poll.ClosesAt
+		polls[postId] = poll
+		withPoll = append(withPoll, postId)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil || len(polls) == 0 {
+		return polls, err
+	}
+	ids = intArgs(withPoll)
+
+	rows, err = db.Query("SELECT post_id, id, text, (SELECT COUNT(*) FROM poll_votes WHERE option_id = poll_options.id) FROM poll_options WHERE post_id IN "+inClause(ids)+" ORDER BY position", ids...)
+	if err != nil {
+		return nil, err
+	}
+	positions := map[int]int{}
+	optionPosts := map[int]int{}
+	for rows.Next() {
+		var postId int
+		option := PollOption{}
+		err = rows.Scan(&postId, &(option.Id), &(option.Text), &(option.Votes))
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		poll := polls[postId]
+		if !poll.Anonymous {
+			option.Voters = []PollVoter{}
+		}
+		positions[option.Id] = len(poll.Options)
+		optionPosts[option.Id] = postId
+		poll.Options = append(poll.Options, option)
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT post_id, COUNT(DISTINCT user_id) FROM poll_votes WHERE post_id IN "+inClause(ids)+" GROUP BY post_id", ids...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var postId, voters int
+		err = rows.Scan(&postId, &voters)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		polls[postId].Voters = voters
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	// Voters of anonymous polls are never loaded
+	voters, err := db.Query("SELECT option_id, users.id, users.nick_name FROM poll_votes INNER JOIN polls ON polls.post_id = poll_votes.post_id INNER JOIN users ON users.id = user_id WHERE poll_votes.post_id IN "+inClause(ids)+" AND polls.anonymous = 0 ORDER BY poll_votes.date", ids...)
+	if err != nil {
+		return nil, err
+	}
+	defer voters.Close()
+	for voters.Next() {
+		var optionId int
+		voter := PollVoter{}
+		err = voters.Scan(&optionId, &(voter.Id), &(voter.NickName))
+		if err != nil {
+			return nil, err
+		}
+		if i, ok := positions[optionId]; ok {
+			poll := polls[optionPosts[optionId]]
+			poll.Options[i].Voters = append(poll.Options[i].Voters, voter)
+		}
+	}
+	err = voters.Err()
+	if err != nil {
+		return nil, err
+	}
+	return polls, nil
+}
+
+// Ids of options of post's poll userId has voted for
+func getPollVotes(postId int, userId int) ([]int, error) {
+	votes, err := getPollVotesOf([]int{postId}, userId)
+	if err != nil {
+		return nil, err
+	}
+	return votes[postId], nil
+}
+
+// Ids of options userId has voted for in polls of several posts at once,
+// mapped to post id. Every post id has entry
+func getPollVotesOf(postIds []int, userId int) (map[int][]int, error) {
+	ids := intArgs(postIds)
+	rows, err := db.Query("SELECT post_id, option_id FROM poll_votes WHERE user_id = ? AND post_id IN "+inClause(ids)+" ORDER BY option_id", append([]interface{}{userId}, ids...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := map[int][]int{}
+	for _, id := range postIds {
+		votes[id] = []int{}
+	}
+	for rows.Next() {
+		var postId, optionId int
+		err = rows.Scan(&postId, &optionId)
+		if err != nil {
+			return nil, err
+		}
+		votes[postId] = append(votes[postId], optionId)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return votes, nil
+}
+
+// Replace votes of userId in post's poll with optionIds. Empty optionIds removes vote
+func setPollVotes(postId int, userId int, optionIds []int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM poll_votes WHERE post_id = ? AND user_id = ?", postId, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	date := getCurrentMilli()
+	for _, optionId := range optionIds {
+		_, err = tx.Exec("INSERT INTO poll_votes (option_id, post_id, user_id, date) VALUES(?,?,?,?)", optionId, postId, userId, date)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func deletePoll(postId int) error {
+	_, err := db.Exec("DELETE FROM poll_votes WHERE post_id = ?", postId)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM poll_options WHERE post_id = ?", postId)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM polls WHERE post_id = ?", postId)
+	return err
+}
@@ -1,12 +1,18 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 )
 
-//      _________posts________________________________________________
-//     |  id       |  date     |  user_id  |  content  |  categories  |
-//     |  INTEGER  |  INTEGER  |  INTEGER  |  TEXT     |  TEXT        |
+//      _________posts____________________________________________________________________________________________________________________________________
+//     |  id       |  date     |  user_id  |  content  |  categories  |  hidden   |  title  |  pinned   |  pinned_category  |  locked   |  publish_at  |
+//     |  INTEGER  |  INTEGER  |  INTEGER  |  TEXT     |  TEXT        |  INTEGER  |  TEXT   |  INTEGER  |  TEXT             |  INTEGER  |  INTEGER     |
+//
+// Pinned post with empty pinned_category is shown first in every feed, otherwise only in feed of that category.
+// Locked posts do not accept new comments.
+// publish_at is time scheduled post gets published, 0 once it is published
 
 func creratePostsTable() error {
 	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS posts(id INTEGER PRIMARY KEY, date INTEGER NOT NULL, user_id INTEGER NOT NULL, content TEXT NOT NULL, categories TEXT)")
@@ -18,11 +24,32 @@ func creratePostsTable() error {
 	if err != nil {
 		return err
 	}
-	return nil
+	// Hidden by moderators
+	err = addColumn("posts", "hidden", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumn("posts", "title", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumn("posts", "pinned", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumn("posts", "pinned_category", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumn("posts", "locked", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	return addColumn("posts", "publish_at", "INTEGER NOT NULL DEFAULT 0")
 }
 
 func insertPost(user *User, post *Post) error {
-	statement, err := db.Prepare("INSERT INTO posts (date, user_id, content, categories) VALUES(?,?,?,?)")
+	statement, err := db.Prepare("INSERT INTO posts (date, user_id, title, content, categories, publish_at) VALUES(?,?,?,?,?,?)")
 	if err != nil {
 		return err
 	}
@@ -32,37 +59,64 @@ func insertPost(user *User, post *Post) error {
 	if err != nil {
 		categories = []byte("[]")
 	}
-	_, err = statement.Exec(date, user.Id, post.Content, string(categories))
+	result, err := statement.Exec(date, user.Id, post.Title, post.Content, string(categories), post.PublishAt)
 	if err != nil {
 		return err
 	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	post.Id = int(id)
+	post.Date = int(date)
 	return nil
 }
 
-func getPosts(user *User) (*[]Post, error) {
+// Posts of category, or all posts if category is empty, newest first.
+// Posts pinned globally or in category come before others.
+// If following is true only posts by users or in categories user follows are included
+func getPosts(user *User, category string, following bool) (*[]Post, error) {
 	posts := []Post{}
 
 	if user == nil {
 		return nil, nil
 	}
 
-	sql := `
-	SELECT posts.id, date, user_id, users.nick_name, content, categories
+	args := []interface{}{CONTENT_POST, user.Id}
+	where := "posts.hidden = 0 AND posts.publish_at = 0"
+	if category != "" {
+		where += " AND EXISTS (SELECT 1 FROM json_each(posts.categories) WHERE value = ?)"
+		args = append(args, category)
+	}
+	if following {
+		where += ` AND (user_id IN (SELECT followed_id FROM follows WHERE user_id = ?)
+		OR EXISTS (SELECT 1 FROM json_each(posts.categories) WHERE value IN (SELECT category FROM category_follows WHERE user_id = ?)))`
+		args = append(args, user.Id, user.Id)
+	}
+	args = append(args, category)
+
+	sql := fmt.Sprintf(`
+	SELECT posts.id, posts.date, user_id, users.nick_name, users.avatar, title, content, categories, pinned, pinned_category, locked,
+	(SELECT COUNT(*) FROM comments WHERE post_id = posts.id AND content IS NOT NULL AND hidden = 0),
+	EXISTS (SELECT 1 FROM bookmarks WHERE content_type = ? AND content_id = posts.id AND bookmarks.user_id = ?)
 	FROM posts
 	INNER JOIN users
 	ON user_id = users.id
-	ORDER BY date DESC`
-	rows, err := db.Query(sql)
+	WHERE %v
+	ORDER BY (pinned = 1 AND (pinned_category = '' OR pinned_category = ?)) DESC, posts.date DESC`, where)
+	rows, err := db.Query(sql, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 	for rows.Next() {
 		post := Post{}
-		var categories string
-		err = rows.Scan(&(post.Id), &(post.Date), &(post.UserId), &(post.NickName), &(post.Content), &categories)
+		var categories, avatar string
+		err = rows.Scan(&(post.Id), &(post.Date), &(post.UserId), &(post.NickName), &avatar, &(post.Title), &(post.Content), &categories, &(post.Pinned), &(post.PinnedCategory), &(post.Locked), &(post.NumberOfComments), &(post.Bookmarked))
 		if err != nil {
 			return nil, err
 		}
+		post.AvatarUrl = avatarUrl(post.UserId, avatar)
 		var arr []string
 		err = json.Unmarshal([]byte(categories), &arr)
 
@@ -71,20 +125,52 @@ func getPosts(user *User) (*[]Post, error) {
 		} else {
 			post.Categories = []string{}
 		}
-		numberOfComments, err := getNumberOfComments(post.Id)
-		if err != nil {
-			return nil, err
-		}
-		if numberOfComments == -1 {
-			numberOfComments = 0
-		}
-		post.NumberOfComments = numberOfComments
 		posts = append(posts, post)
 	}
 	err = rows.Err()
 	if err != nil {
 		return nil, err
 	}
+	rows.Close()
+
+	// Everything else is looked up for all posts at once
+	ids := make([]int, len(posts))
+	contents := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+		contents[i] = post.Content
+	}
+	mentions, err := getMentionsOf(CONTENT_POST, ids)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := getAttachmentsOf(CONTENT_POST, ids)
+	if err != nil {
+		return nil, err
+	}
+	previews, err := getLinkPreviewsOf(contents)
+	if err != nil {
+		return nil, err
+	}
+	polls, err := getPolls(ids)
+	if err != nil {
+		return nil, err
+	}
+	votes, err := getPollVotesOf(ids, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range posts {
+		post := &posts[i]
+		post.ContentHtml = renderMarkdown(post.Content)
+		post.Mentions = mentions[post.Id]
+		post.Attachments = attachments[post.Id]
+		post.LinkPreviews = previews[i]
+		post.Poll = polls[post.Id]
+		if post.Poll != nil {
+			post.Poll.Voted = votes[post.Id]
+		}
+	}
 	return &posts, nil
 }
 
@@ -92,22 +178,23 @@ func getPost(postId int) (*Post, error) {
 	post := Post{}
 
 	sql := `
-	SELECT posts.id, date, user_id, users.nick_name, content, categories
+	SELECT posts.id, posts.date, user_id, users.nick_name, users.avatar, title, content, categories, pinned, pinned_category, locked
 	FROM posts
 	INNER JOIN users
 	ON user_id = users.id
-	WHERE posts.id = ?
+	WHERE posts.id = ? AND posts.hidden = 0 AND posts.publish_at = 0
 	LIMIT 1`
 	rows, err := db.Query(sql, postId)
 	if err != nil {
 		return nil, err
 	}
 	for rows.Next() {
-		var categories string
-		err = rows.Scan(&(post.Id), &(post.Date), &(post.UserId), &(post.NickName), &(post.Content), &categories)
+		var categories, avatar string
+		err = rows.Scan(&(post.Id), &(post.Date), &(post.UserId), &(post.NickName), &avatar, &(post.Title), &(post.Content), &categories, &(post.Pinned), &(post.PinnedCategory), &(post.Locked))
 		if err != nil {
 			return nil, err
 		}
+		post.AvatarUrl = avatarUrl(post.UserId, avatar)
 		var arr []string
 		err = json.Unmarshal([]byte(categories), &arr)
 
@@ -130,6 +217,209 @@ func getPost(postId int) (*Post, error) {
 		numberOfComments = 0
 	}
 	post.NumberOfComments = numberOfComments
+	post.ContentHtml = renderMarkdown(post.Content)
+	post.Mentions, err = getMentions(CONTENT_POST, post.Id)
+	if err != nil {
+		return nil, err
+	}
+	post.Attachments, err = getAttachments(CONTENT_POST, post.Id)
+	if err != nil {
+		return nil, err
+	}
+	post.LinkPreviews, err = getLinkPreviews(post.Content)
+	if err != nil {
+		return nil, err
+	}
+	post.Poll, err = getPoll(post.Id)
+	if err != nil {
+		return nil, err
+	}
 
 	return &post, nil
 }
+
+// Pin post globally if category is empty, or in category
+func pinPost(postId int, pinned bool, category string) error {
+	if !pinned {
+		category = ""
+	}
+	_, err := db.Exec("UPDATE posts SET pinned = ?, pinned_category = ? WHERE id = ?", pinned, category, postId)
+	return err
+}
+
+func lockPost(postId int, locked bool) error {
+	_, err := db.Exec("UPDATE posts SET locked = ? WHERE id = ?", locked, postId)
+	return err
+}
+
+// Scheduled posts of userId, next to be published first
+func getScheduledPosts(userId int) ([]Post, error) {
+	sql := `
+	SELECT posts.id, posts.date, user_id, users.nick_name, users.avatar, title, content, categories, publish_at
+	FROM posts
+	INNER JOIN users
+	ON user_id = users.id
+	WHERE user_id = ? AND publish_at > 0
+	ORDER BY publish_at`
+	rows, err := db.Query(sql, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		post := Post{}
+		var categories, avatar string
+		err = rows.Scan(&(post.Id), &(post.Date), &(post.UserId), &(post.NickName), &avatar, &(post.Title), &(post.Content), &categories, &(post.PublishAt))
+		if err != nil {
+			return nil, err
+		}
+		post.AvatarUrl = avatarUrl(post.UserId, avatar)
+		err = json.Unmarshal([]byte(categories), &(post.Categories))
+		if err != nil || post.Categories == nil {
+			post.Categories = []string{}
+		}
+		post.ContentHtml = renderMarkdown(post.Content)
+		post.Mentions = []Mention{}
+		post.LinkPreviews = []LinkPreview{}
+		post.Attachments, err = getAttachments(CONTENT_POST, post.Id)
+		if err != nil {
+			return nil, err
+		}
+		post.Poll, err = getPoll(post.Id)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// Ids of scheduled posts due at date
+func getDuePosts(date int64) ([]int, error) {
+	rows, err := db.Query("SELECT id FROM posts WHERE publish_at > 0 AND publish_at <= ?", date)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Author of scheduled post. Returns -1 if there is no such scheduled post
+func getScheduledPostUserId(postId int) (int, error) {
+	userId := -1
+	err := db.QueryRow("SELECT user_id FROM posts WHERE id = ? AND publish_at > 0", postId).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+	return userId, err
+}
+
+// Make scheduled post visible with date of publishing, if it is still due.
+// Returns false if it was rescheduled or canceled meanwhile
+func markPostPublished(postId int, date int64) (bool, error) {
+	result, err := db.Exec("UPDATE posts SET publish_at = 0, date = ? WHERE id = ? AND publish_at > 0 AND publish_at <= ?", date, postId, date)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Returns false if userId has no such scheduled post
+func reschedulePost(userId int, postId int, publishAt int64) (bool, error) {
+	result, err := db.Exec("UPDATE posts SET publish_at = ? WHERE id = ? AND user_id = ? AND publish_at > 0", publishAt, postId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// Delete scheduled post of userId and its attachments.
+// Returns false if userId has no such scheduled post
+func deleteScheduledPost(userId int, postId int) (bool, error) {
+	attachments, err := getAttachments(CONTENT_POST, postId)
+	if err != nil {
+		return false, err
+	}
+	result, err := db.Exec("DELETE FROM posts WHERE id = ? AND user_id = ? AND publish_at > 0", postId, userId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil || affected == 0 {
+		return false, err
+	}
+	removeAttachments(attachments)
+	return true, deletePoll(postId)
+}
+
+// Delete post together with its comments, poll, subscriptions, notifications and attachments
+func deletePost(postId int) error {
+	attachments, err := getPostAttachments(postId)
+	if err != nil {
+		return err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM mentions WHERE (content_type = ? AND content_id = ?) OR (content_type = ? AND content_id IN (SELECT id FROM comments WHERE post_id = ?))", CONTENT_POST, postId, CONTENT_COMMENT, postId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM bookmarks WHERE (content_type = ? AND content_id = ?) OR (content_type = ? AND content_id IN (SELECT id FROM comments WHERE post_id = ?))", CONTENT_POST, postId, CONTENT_COMMENT, postId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM comments WHERE post_id = ?", postId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.Exec("DELETE FROM notifications WHERE post_id = ?", postId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, table := range []string{"poll_votes", "poll_options", "polls", "subscriptions"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE post_id = ?", postId)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	_, err = tx.Exec("DELETE FROM posts WHERE id = ?", postId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	removeAttachments(attachments)
+	return nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Posts created later are newer, even within same millisecond
+func createTestPost(t *testing.T, user *User, content string, categories ...string) *Post {
+	t.Helper()
+	post := &Post{Title: "Title", Content: content, Categories: categories}
+	err := insertPost(user, post)
+	if err == nil {
+		_, err = db.Exec("UPDATE posts SET date = id WHERE id = ?", post.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return post
+}
+
+func postIds(posts *[]Post) []int {
+	ids := []int{}
+	for _, post := range *posts {
+		ids = append(ids, post.Id)
+	}
+	return ids
+}
+
+func TestGetPostsDetails(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "details")
+	voter := createTestUser(t, "voter")
+	plain := createTestPost(t, user, "plain")
+	rich := createTestPost(t, user, "@voter see https://example.com/page **now**")
+
+	err := insertMentions(CONTENT_POST, rich.Id, []Mention{{UserId: voter.Id, Offset: 0, Length: 6}})
+	if err == nil {
+		err = insertAttachment(&Attachment{UserId: user.Id, ContentType: CONTENT_POST, ContentId: rich.Id, StorageKey: "key", FileName: "a.txt", MimeType: "text/plain"})
+	}
+	if err == nil {
+		err = saveLinkPreview(&LinkPreview{Url: "https://example.com/page", Title: "Page"})
+	}
+	poll := &Poll{Question: "Which?", Options: []PollOption{{Text: "A"}, {Text: "B"}}}
+	if err == nil {
+		err = insertPoll(rich.Id, poll)
+	}
+	if err == nil {
+		err = setPollVotes(rich.Id, voter.Id, []int{poll.Options[1].Id})
+	}
+	if err == nil {
+		err = setPollVotes(rich.Id, user.Id, []int{poll.Options[0].Id})
+	}
+	if err == nil {
+		err = saveComment(&Comment{UserId: voter.Id, PostId: rich.Id, Content: "reply"})
+	}
+	if err == nil {
+		err = saveBookmark(user.Id, CONTENT_POST, rich.Id, 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := getPosts(user, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*posts) != 2 || (*posts)[0].Id != rich.Id || (*posts)[1].Id != plain.Id {
+		t.Fatalf("posts: got %v", postIds(posts))
+	}
+	got := (*posts)[0]
+	if got.NumberOfComments != 1 || !got.Bookmarked {
+		t.Errorf("comments %v, bookmarked %v", got.NumberOfComments, got.Bookmarked)
+	}
+	if len(got.Mentions) != 1 || got.Mentions[0].NickName != "voter" {
+		t.Errorf("mentions: %+v", got.Mentions)
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0].FileName != "a.txt" {
+		t.Errorf("attachments: %+v", got.Attachments)
+	}
+	if len(got.LinkPreviews) != 1 || got.LinkPreviews[0].Title != "Page" {
+		t.Errorf("link previews: %+v", got.LinkPreviews)
+	}
+	if got.Poll == nil || got.Poll.Voters != 2 || fmt.Sprint(got.Poll.Voted) != fmt.Sprint([]int{poll.Options[0].Id}) ||
+		got.Poll.Options[1].Votes != 1 || len(got.Poll.Options[1].Voters) != 1 || got.Poll.Options[1].Voters[0].Id != voter.Id {
+		t.Errorf("poll: %+v", got.Poll)
+	}
+
+	// Posts without details have empty lists, not null
+	other := (*posts)[1]
+	if other.Mentions == nil || other.Attachments == nil || other.LinkPreviews == nil || other.Poll != nil || other.Bookmarked {
+		t.Errorf("plain post: %+v", other)
+	}
+}
+
+func TestGetPostsPinnedFirst(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "pinner")
+	oldest := createTestPost(t, user, "oldest", "cats")
+	inCats := createTestPost(t, user, "pinned in cats", "cats")
+	global := createTestPost(t, user, "pinned everywhere", "dogs")
+	newest := createTestPost(t, user, "newest", "cats")
+	err := pinPost(inCats.Id, true, "cats")
+	if err == nil {
+		err = pinPost(global.Id, true, "")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := getPosts(user, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Post pinned in category is pinned only in that category
+	if fmt.Sprint(postIds(posts)) != fmt.Sprint([]int{global.Id, newest.Id, inCats.Id, oldest.Id}) {
+		t.Errorf("all posts: got %v", postIds(posts))
+	}
+	posts, err = getPosts(user, "cats", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(postIds(posts)) != fmt.Sprint([]int{inCats.Id, newest.Id, oldest.Id}) {
+		t.Errorf("cats: got %v", postIds(posts))
+	}
+
+	err = pinPost(global.Id, false, "dogs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts, err = getPosts(user, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(postIds(posts)) != fmt.Sprint([]int{newest.Id, global.Id, inCats.Id, oldest.Id}) {
+		t.Errorf("after unpin: got %v", postIds(posts))
+	}
+}
+
+func TestGetPostsFilters(t *testing.T) {
+	setupTestDB(t)
+	reader := createTestUser(t, "reader")
+	author := createTestUser(t, "author")
+	other := createTestUser(t, "other")
+	byAuthor := createTestPost(t, author, "by author", "cats")
+	inDogs := createTestPost(t, other, "in dogs", "dogs", "birds")
+	unrelated := createTestPost(t, other, "unrelated", "cats")
+	hidden := createTestPost(t, author, "hidden", "dogs")
+	_, err := db.Exec("UPDATE posts SET hidden = 1 WHERE id = ?", hidden.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := getPosts(reader, "cats", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(postIds(posts)) != fmt.Sprint([]int{unrelated.Id, byAuthor.Id}) {
+		t.Errorf("category: got %v", postIds(posts))
+	}
+
+	_, err = insertFollow(reader.Id, author.Id)
+	if err == nil {
+		err = insertCategoryFollow(reader.Id, "dogs")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	posts, err = getPosts(reader, "", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(postIds(posts)) != fmt.Sprint([]int{inDogs.Id, byAuthor.Id}) {
+		t.Errorf("following: got %v", postIds(posts))
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+//      _________privacy_________________________________________________________________________________
+//     |  user_id  |  first_name  |  last_name  |  age      |  gender   |  email    |  contacts_only  |
+//     |  INTEGER  |  INTEGER     |  INTEGER    |  INTEGER  |  INTEGER  |  INTEGER  |  INTEGER        |
+//
+// 1 - field is visible to other users, 0 - field is hidden.
+// Users without a row keep all fields hidden.
+// contacts_only 1 - messages from strangers become message requests
+
+func creratePrivacyTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS privacy(user_id INTEGER PRIMARY KEY, first_name INTEGER NOT NULL DEFAULT 0, last_name INTEGER NOT NULL DEFAULT 0, age INTEGER NOT NULL DEFAULT 0, gender INTEGER NOT NULL DEFAULT 0, email INTEGER NOT NULL DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return addColumn("privacy", "contacts_only", "INTEGER NOT NULL DEFAULT 0")
+}
+
+func getPrivacy(userId int) (*Privacy, error) {
+	privacy := Privacy{}
+	rows, err := db.Query("SELECT first_name, last_name, age, gender, email, contacts_only FROM privacy WHERE user_id = ? LIMIT 1", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&(privacy.FirstName), &(privacy.LastName), &(privacy.Age), &(privacy.Gender), &(privacy.Email), &(privacy.ContactsOnly))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &privacy, nil
+}
+
+func savePrivacy(userId int, privacy *Privacy) error {
+	statement, err := db.Prepare("INSERT OR REPLACE INTO privacy (user_id, first_name, last_name, age, gender, email, contacts_only) VALUES(?,?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(userId, privacy.FirstName, privacy.LastName, privacy.Age, privacy.Gender, privacy.Email, privacy.ContactsOnly)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Fill in join date, number of posts, comments, followers and followed users
+func getProfileStats(profile *Profile) error {
+	sql := `
+	SELECT users.date,
+	(SELECT COUNT(*) FROM posts WHERE posts.user_id = users.id AND posts.hidden = 0 AND posts.publish_at = 0),
+	(SELECT COUNT(*) FROM comments WHERE comments.user_id = users.id),
+	(SELECT COUNT(*) FROM follows WHERE follows.followed_id = users.id),
+	(SELECT COUNT(*) FROM follows WHERE follows.user_id = users.id)
+	FROM users
+	WHERE users.id = ?
+	`
+	rows, err := db.Query(sql, profile.User.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		err = rows.Scan(&(profile.JoinDate), &(profile.NumberOfPosts), &(profile.NumberOfComments), &(profile.Followers), &(profile.Following))
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+//      _________reports_____________________________________________________________________________________________________________
+//     |  id       |  content_type  |  content_id  |  reporter_id  |  reason  |  date     |  status  |  moderator_id  |  action_date  |
+//     |  INTEGER  |  TEXT          |  INTEGER     |  INTEGER      |  TEXT    |  INTEGER  |  TEXT    |  INTEGER       |  INTEGER      |
+//
+//      _________warnings______________________________________________
+//     |  id       |  user_id  |  moderator_id  |  reason  |  date     |
+//     |  INTEGER  |  INTEGER  |  INTEGER       |  TEXT    |  INTEGER  |
+
+// User has at most one open report about the same content, and can report it
+// again once moderator closed earlier report
+func crerateReportsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS reports(id INTEGER PRIMARY KEY, content_type TEXT NOT NULL, content_id INTEGER NOT NULL, reporter_id INTEGER NOT NULL, reason TEXT NOT NULL, date INTEGER NOT NULL, status TEXT NOT NULL, moderator_id INTEGER NOT NULL DEFAULT 0, action_date INTEGER NOT NULL DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS reports_open ON reports(content_type, content_id, reporter_id) WHERE status = '%v'", REPORT_OPEN))
+	return err
+}
+
+func crerateWarningsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS warnings(id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, moderator_id INTEGER NOT NULL, reason TEXT NOT NULL, date INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Table and author column of each content type
+var contentTables = map[string][2]string{
+	CONTENT_POST:    {"posts", "user_id"},
+	CONTENT_COMMENT: {"comments", "user_id"},
+	CONTENT_MESSAGE: {"messages", "from_id"},
+}
+
+func insertReport(report *Report) error {
+	statement, err := db.Prepare("INSERT INTO reports (content_type, content_id, reporter_id, reason, date, status) VALUES(?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(report.ContentType, report.ContentId, report.ReporterId, report.Reason, getCurrentMilli(), REPORT_OPEN)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Number of distinct users with open reports about content
+func countOpenReports(contentType string, contentId int) (int, error) {
+	count := 0
+	err := db.QueryRow("SELECT COUNT(DISTINCT reporter_id) FROM reports WHERE content_type = ? AND content_id = ? AND status = ?", contentType, contentId, REPORT_OPEN).Scan(&count)
+	return count, err
+}
+
+// Open reports with reported content, oldest first
+func getOpenReports() ([]*Report, error) {
+	sql := `
+	SELECT reports.id, content_type, content_id, reporter_id, users.nick_name, reason, reports.date, status
+	FROM reports
+	INNER JOIN users ON users.id = reporter_id
+	WHERE status = ?
+	ORDER BY reports.date ASC
+	`
+	rows, err := db.Query(sql, REPORT_OPEN)
+	if err != nil {
+		return nil, err
+	}
+	reports := []*Report{}
+	for rows.Next() {
+		report := Report{}
+		err = rows.Scan(&(report.Id), &(report.ContentType), &(report.ContentId), &(report.ReporterId), &(report.ReporterNickName), &(report.Reason), &(report.Date), &(report.Status))
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		reports = append(reports, &report)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, report := range reports {
+		err = getReportedContent(report)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return reports, nil
+}
+
+func getReport(id int) (*Report, error) {
+	report := Report{}
+	err := db.QueryRow("SELECT id, content_type, content_id, reporter_id, reason, date, status FROM reports WHERE id = ?", id).Scan(&(report.Id), &(report.ContentType), &(report.ContentId), &(report.ReporterId), &(report.Reason), &(report.Date), &(report.Status))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = getReportedContent(&report)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// Fill in content, author and hidden flag. Content of deleted items stays empty
+func getReportedContent(report *Report) error {
+	table, ok := contentTables[report.ContentType]
+	if !ok {
+		return fmt.Errorf("unknown content type %v", report.ContentType)
+	}
+	query := fmt.Sprintf("SELECT t.content, t.%v, users.nick_name, t.hidden FROM %v t INNER JOIN users ON users.id = t.%v WHERE t.id = ?", table[1], table[0], table[1])
+	err := db.QueryRow(query, report.ContentId).Scan(&(report.Content), &(report.AuthorId), &(report.AuthorNickName), &(report.Hidden))
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	return err
+}
+
+// Close all open reports about content with moderator's action
+func resolveReports(contentType string, contentId int, status string, moderatorId int) error {
+	statement, err := db.Prepare("UPDATE reports SET status = ?, moderator_id = ?, action_date = ? WHERE content_type = ? AND content_id = ? AND status = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(status, moderatorId, getCurrentMilli(), contentType, contentId, REPORT_OPEN)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func setContentHidden(contentType string, contentId int, hidden bool) error {
+	table, ok := contentTables[contentType]
+	if !ok {
+		return fmt.Errorf("unknown content type %v", contentType)
+	}
+	_, err := db.Exec(fmt.Sprintf("UPDATE %v SET hidden = ? WHERE id = ?", table[0]), hidden, contentId)
+	return err
+}
+
+func insertWarning(userId int, moderatorId int, reason string) error {
+	statement, err := db.Prepare("INSERT INTO warnings (user_id, moderator_id, reason, date) VALUES(?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(userId, moderatorId, reason, getCurrentMilli())
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import "database/sql"
+
+//      _________sanctions_________________________________________________________________________________
+//     |  id       |  user_id  |  type  |  reason  |  expires  |  moderator_id  |  date     |  revoked  |
+//     |  INTEGER  |  INTEGER  |  TEXT  |  TEXT    |  INTEGER  |  INTEGER       |  INTEGER  |  INTEGER  |
+//
+//      _________ip_bans_____________________________________________________________
+//     |  id       |  cidr  |  reason  |  expires  |  moderator_id  |  date     |
+//     |  INTEGER  |  TEXT  |  TEXT    |  INTEGER  |  INTEGER       |  INTEGER  |
+//
+// expires is 0 for permanent sanctions
+
+func crerateSanctionsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS sanctions(id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, type TEXT NOT NULL, reason TEXT NOT NULL, expires INTEGER NOT NULL, moderator_id INTEGER NOT NULL, date INTEGER NOT NULL, revoked INTEGER NOT NULL DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func crerateIpBansTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS ip_bans(id INTEGER PRIMARY KEY, cidr TEXT NOT NULL, reason TEXT NOT NULL, expires INTEGER NOT NULL, moderator_id INTEGER NOT NULL, date INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func insertSanction(sanction *Sanction) error {
+	statement, err := db.Prepare("INSERT INTO sanctions (user_id, type, reason, expires, moderator_id, date) VALUES(?,?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	sanction.Date = getCurrentMilli()
+	result, err := statement.Exec(sanction.UserId, sanction.Type, sanction.Reason, sanction.Expires, sanction.ModeratorId, sanction.Date)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	sanction.Id = int(id)
+	return nil
+}
+
+// Returns nil if there is no such sanction
+func getSanction(id int) (*Sanction, error) {
+	sanction := Sanction{}
+	err := db.QueryRow("SELECT id, user_id, type, reason, expires, moderator_id, date FROM sanctions WHERE id = ?", id).Scan(&(sanction.Id), &(sanction.UserId), &(sanction.Type), &(sanction.Reason), &(sanction.Expires), &(sanction.ModeratorId), &(sanction.Date))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sanction, nil
+}
+
+func revokeSanction(id int) error {
+	_, err := db.Exec("UPDATE sanctions SET revoked = 1 WHERE id = ?", id)
+	return err
+}
+
+// Active sanctions of user, or of all users if userId is -1
+func getActiveSanctions(userId int) ([]*Sanction, error) {
+	sql := `
+	SELECT id, user_id, type, reason, expires, moderator_id, date
+	FROM sanctions
+	WHERE revoked = 0 AND (expires = 0 OR expires > ?) AND (? = -1 OR user_id = ?)
+	ORDER BY date DESC
+	`
+	rows, err := db.Query(sql, getCurrentMilli(), userId, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sanctions := []*Sanction{}
+	for rows.Next() {
+		sanction := Sanction{}
+		err = rows.Scan(&(sanction.Id), &(sanction.UserId), &(sanction.Type), &(sanction.Reason), &(sanction.Expires), &(sanction.ModeratorId), &(sanction.Date))
+		if err != nil {
+			return nil, err
+		}
+		sanctions = append(sanctions, &sanction)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return sanctions, nil
+}
+
+// Active sanction of given type that ends last, or nil
+func getActiveSanction(userId int, sanctionType string) (*Sanction, error) {
+	sanctions, err := getActiveSanctions(userId)
+	if err != nil {
+		return nil, err
+	}
+	var result *Sanction = nil
+	for _, sanction := range sanctions {
+		if sanction.Type != sanctionType {
+			continue
+		}
+		if result == nil || sanction.Expires == 0 || (result.Expires != 0 && sanction.Expires > result.Expires) {
+			result = sanction
+		}
+		if result.Expires == 0 {
+			break
+		}
+	}
+	return result, nil
+}
+
+func insertIpBan(ban *IpBan) error {
+	statement, err := db.Prepare("INSERT INTO ip_bans (cidr, reason, expires, moderator_id, date) VALUES(?,?,?,?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	ban.Date = getCurrentMilli()
+	result, err := statement.Exec(ban.Cidr, ban.Reason, ban.Expires, ban.ModeratorId, ban.Date)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	ban.Id = int(id)
+	return nil
+}
+
+func deleteIpBan(id int) error {
+	_, err := db.Exec("DELETE FROM ip_bans WHERE id = ?", id)
+	return err
+}
+
+func getActiveIpBans() ([]*IpBan, error) {
+	rows, err := db.Query("SELECT id, cidr, reason, expires, moderator_id, date FROM ip_bans WHERE expires = 0 OR expires > ? ORDER BY date DESC", getCurrentMilli())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := []*IpBan{}
+	for rows.Next() {
+		ban := IpBan{}
+		err = rows.Scan(&(ban.Id), &(ban.Cidr), &(ban.Reason), &(ban.Expires), &(ban.ModeratorId), &(ban.Date))
+		if err != nil {
+			return nil, err
+		}
+		bans = append(bans, &ban)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return bans, nil
+}
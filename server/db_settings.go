@@ -0,0 +1,52 @@
+package main
+
+//       _________settings_________
+//      |  key      |  value      |
+//      |  TEXT     |  TEXT       |
+
+func crerateSettingsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS settings(key TEXT PRIMARY KEY, value TEXT NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Returns empty string if there is no such setting
+func getSetting(key string) (string, error) {
+	rows, err := db.Query("SELECT value FROM settings WHERE key = ?", key)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	value := ""
+	for rows.Next() {
+		err = rows.Scan(&value)
+		if err != nil {
+			return "", err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func saveSetting(key string, value string) error {
+	statement, err := db.Prepare("INSERT OR REPLACE INTO settings (key, value) VALUES(?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(key, value)
+	if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import "fmt"
+
+//      _________subscriptions_____________________________
+//     |  user_id  |  post_id  |  push     |  date     |
+//     |  INTEGER  |  INTEGER  |  INTEGER  |  INTEGER  |
+//
+// Subscribers are notified about new comments on post. Notifications
+// of subscriptions without push are only stored, not sent in real time
+
+func crerateSubscriptionsTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS subscriptions(user_id INTEGER NOT NULL, post_id INTEGER NOT NULL, push INTEGER NOT NULL, date INTEGER NOT NULL, PRIMARY KEY (user_id, post_id))")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS subscriptions_post ON subscriptions(post_id)")
+	return err
+}
+
+// Subscribe userId to post, or change push of existing subscription
+func saveSubscription(userId int, postId int, push bool) error {
+	_, err := db.Exec(`INSERT INTO subscriptions (user_id, post_id, push, date) VALUES(?,?,?,?)
+	ON CONFLICT(user_id, post_id) DO UPDATE SET push = excluded.push`,
+		userId, postId, push, getCurrentMilli())
+	return err
+}
+
+// Subscribe userId to post with push, existing subscription is kept as it is
+func insertSubscription(userId int, postId int) error {
+	_, err := db.Exec("INSERT OR IGNORE INTO subscriptions (user_id, post_id, push, date) VALUES(?,?,?,?)", userId, postId, true, getCurrentMilli())
+	return err
+}
+
+func deleteSubscription(userId int, postId int) error {
+	_, err := db.Exec("DELETE FROM subscriptions WHERE user_id = ? AND post_id = ?", userId, postId)
+	return err
+}
+
+func isSubscribedToPost(userId int, postId int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE user_id = ? AND post_id = ?", userId, postId).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Subscribers of post, mapped to push of their subscription
+func getSubscribers(postId int) (map[int]bool, error) {
+	rows, err := db.Query("SELECT user_id, push FROM subscriptions WHERE post_id = ?", postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscribers := map[int]bool{}
+	for rows.Next() {
+		var id int
+		var push bool
+		err = rows.Scan(&id, &push)
+		if err != nil {
+			return nil, err
+		}
+		subscribers[id] = push
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return subscribers, nil
+}
+
+// Subscriptions of userId to visible posts, newest first, 10 per page
+func getSubscriptions(userId int, page int) ([]Subscription, error) {
+	offset := (page - 1) * 10
+
+	query := fmt.Sprintf(`
+	SELECT post_id, push, date
+	FROM subscriptions
+	WHERE user_id = ? AND post_id IN (SELECT id FROM posts WHERE hidden = 0 AND publish_at = 0)
+	ORDER BY date DESC
+	LIMIT 10 OFFSET %v
+	`, offset)
+	rows, err := db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []Subscription{}
+	for rows.Next() {
+		var s Subscription
+		err = rows.Scan(&(s.PostId), &(s.Push), &(s.Date))
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
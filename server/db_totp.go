@@ -0,0 +1,177 @@
+package main
+
+//      _________users (two-factor columns)________________
+//     |  totp_secret  |  totp_enabled  |  totp_last_step  |
+//     |  TEXT         |  INTEGER       |  INTEGER         |
+//
+//      _________recovery_codes___________________________
+//     |  id       |  user_id  |  code_hash  |  used     |
+//     |  INTEGER  |  INTEGER  |  TEXT       |  INTEGER  |
+//
+//      _________totp_challenges_____________________
+//     |  token_hash  |  user_id  |  expires   |
+//     |  TEXT        |  INTEGER  |  INTEGER   |
+//
+// Challenge is deleted once code is checked against it, right or wrong
+
+func crerateRecoveryCodesTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS recovery_codes(id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL, code_hash TEXT NOT NULL, used INTEGER NOT NULL DEFAULT 0)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func crerateTOTPChallengesTable() error {
+	statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS totp_challenges(token_hash TEXT PRIMARY KEY, user_id INTEGER NOT NULL, expires INTEGER NOT NULL)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Save issued challenge. Expired challenges are dropped
+func insertTOTPChallenge(userId int, tokenHash string, expires int64) error {
+	_, err := db.Exec("DELETE FROM totp_challenges WHERE expires < ?", getCurrentMilli())
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("INSERT OR REPLACE INTO totp_challenges (token_hash, user_id, expires) VALUES(?,?,?)", tokenHash, userId, expires)
+	return err
+}
+
+// Delete challenge. Returns false if it was not issued, already used or expired
+func useTOTPChallenge(tokenHash string) (bool, error) {
+	result, err := db.Exec("DELETE FROM totp_challenges WHERE token_hash = ? AND expires >= ?", tokenHash, getCurrentMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func getTOTP(userId int) (*TOTP, error) {
+	rows, err := db.Query("SELECT totp_secret, totp_enabled, totp_last_step FROM users WHERE id = ?", userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	totp := TOTP{}
+	for rows.Next() {
+		err = rows.Scan(&(totp.Secret), &(totp.Enabled), &(totp.LastStep))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// Save secret and enabled flag. Empty secret turns two-factor authentication off
+func saveTOTP(userId int, secret string, enabled bool) error {
+	statement, err := db.Prepare("UPDATE users SET totp_secret = ?, totp_enabled = ?, totp_last_step = 0 WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(secret, enabled, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Remember time step of accepted code so the same code cannot be used twice.
+// Returns false if this or a later step was already used
+func useTOTPStep(userId int, step int64) (bool, error) {
+	result, err := db.Exec("UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userId, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Replace all recovery codes of user with new ones
+func saveRecoveryCodes(userId int, codeHashes []string) error {
+	err := deleteRecoveryCodes(userId)
+	if err != nil {
+		return err
+	}
+	statement, err := db.Prepare("INSERT INTO recovery_codes (user_id, code_hash) VALUES(?,?)")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	for _, hash := range codeHashes {
+		_, err = statement.Exec(userId, hash)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteRecoveryCodes(userId int) error {
+	_, err := db.Exec("DELETE FROM recovery_codes WHERE user_id = ?", userId)
+	return err
+}
+
+// Mark matching unused recovery code as used. Returns false if there is no such code
+func useRecoveryCode(userId int, code string) (bool, error) {
+	rows, err := db.Query("SELECT id, code_hash FROM recovery_codes WHERE user_id = ? AND used = 0", userId)
+	if err != nil {
+		return false, err
+	}
+	id := -1
+	for rows.Next() {
+		var codeId int
+		var hash string
+		err = rows.Scan(&codeId, &hash)
+		if err != nil {
+			rows.Close()
+			return false, err
+		}
+		if compairPasswords(hash, code) {
+			id = codeId
+			break
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return false, err
+	}
+	if id == -1 {
+		return false, nil
+	}
+
+	result, err := db.Exec("UPDATE recovery_codes SET used = 1 WHERE id = ? AND used = 0", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
@@ -1,15 +1,21 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-//     _________users_______________________________________________________________________________________________
-//     |  id      |  first_name  |  last_name  |  age  |  gender  |  nick_name  |  email   |  password | session_id |
-//     |  INTEGER |  TEXT        |  TEXT       |  int  |  TEXT    |  TEXT       |  TEXT    |  TEXT     | TEXT       |
+//     _________users________________________________________________________________________________________________________________________________________________________
+//     |  id      |  first_name  |  last_name  |  age  |  gender  |  nick_name  |  email   |  password | session_id |  date     |  verified  | verification_sent |  role  |  avatar  |
+//     |  INTEGER |  TEXT        |  TEXT       |  int  |  TEXT    |  TEXT       |  TEXT    |  TEXT     | TEXT       |  INTEGER  |  INTEGER   | INTEGER           |  TEXT  |  TEXT    |
+//
+// avatar is content hash of uploaded avatar, empty if user has generated identicon
+
+// Columns read by scanUser, in scan order
+const userColumns = "id, first_name, last_name, age, gender, nick_name, email, password, session_id, verified, role, avatar"
 
 func crerateUsersTable() error {
 	sql := "CREATE TABLE IF NOT EXISTS users (id INTEGER PRIMARY KEY, first_name TEXT, last_name TEXT, age INTEGER, gender TEXT NOT NULL, nick_name TEXT NOT NULL UNIQUE, email TEXT NOT NULL UNIQUE, password TEXT NOT NULL, session_id TEXT)"
@@ -23,11 +29,52 @@ func crerateUsersTable() error {
 	if err != nil {
 		return err
 	}
-	return nil
+	// Columns added after the first release
+	err = addColumn("users", "date", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	// Accounts created before email verification are treated as verified
+	err = addColumn("users", "verified", "INTEGER NOT NULL DEFAULT 1")
+	if err != nil {
+		return err
+	}
+	err = addColumn("users", "verification_sent", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	// Two-factor authentication, see db_totp.go
+	err = addColumn("users", "totp_secret", "TEXT NOT NULL DEFAULT ''")
+	if err != nil {
+		return err
+	}
+	err = addColumn("users", "totp_enabled", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumn("users", "totp_last_step", "INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+	err = addColumn("users", "role", "TEXT NOT NULL DEFAULT 'member'")
+	if err != nil {
+		return err
+	}
+	return addColumn("users", "avatar", "TEXT NOT NULL DEFAULT ''")
+}
+
+func scanUser(rows *sql.Rows) (*User, error) {
+	user := User{}
+	err := rows.Scan(&(user.Id), &(user.FirstName), &(user.LastName), &(user.Age), &(user.Gender), &(user.NickName), &(user.Email), &(user.Password), &(user.SessionId), &(user.Verified), &(user.Role), &(user.Avatar))
+	if err != nil {
+		return nil, err
+	}
+	user.AvatarUrl = avatarUrl(user.Id, user.Avatar)
+	return &user, nil
 }
 
 func getUsers() ([]*User, error) {
-	rows, err := db.Query("SELECT id, nick_name FROM users ORDER BY nick_name COLLATE NOCASE ASC")
+	rows, err := db.Query("SELECT id, nick_name, avatar FROM users ORDER BY nick_name COLLATE NOCASE ASC")
 	if err != nil {
 		return nil, err
 	}
@@ -36,10 +83,11 @@ func getUsers() ([]*User, error) {
 	users := []*User{}
 	for rows.Next() {
 		user := User{}
-		err = rows.Scan(&(user.Id), &(user.NickName))
+		err = rows.Scan(&(user.Id), &(user.NickName), &(user.Avatar))
 		if err != nil {
 			return nil, err
 		}
+		user.AvatarUrl = avatarUrl(user.Id, user.Avatar)
 		users = append(users, &user)
 	}
 	err = rows.Err()
@@ -50,12 +98,15 @@ func getUsers() ([]*User, error) {
 }
 
 func saveUser(user *User) (int64, error) {
-	statement, err := db.Prepare("INSERT INTO users (first_name, last_name, age, gender, nick_name, email, password, session_id) VALUES(?, ?, ?, ?, ?, ?, ?, ?)")
+	if user.Role == "" {
+		user.Role = ROLE_MEMBER
+	}
+	statement, err := db.Prepare("INSERT INTO users (first_name, last_name, age, gender, nick_name, email, password, session_id, date, verified, role) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
 	if err != nil {
 		return -1, err
 	}
 	defer statement.Close()
-	result, err := statement.Exec(user.FirstName, user.LastName, user.Age, user.Gender, user.NickName, strings.ToLower(user.Email), user.Password, user.SessionId)
+	result, err := statement.Exec(user.FirstName, user.LastName, user.Age, user.Gender, user.NickName, strings.ToLower(user.Email), user.Password, user.SessionId, getCurrentMilli(), user.Verified, user.Role)
 	if err != nil {
 		return -1, err
 	}
@@ -67,18 +118,17 @@ func saveUser(user *User) (int64, error) {
 }
 
 func printUsers() error {
-	rows, err := db.Query("SELECT * FROM users")
+	rows, err := db.Query("SELECT " + userColumns + " FROM users")
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		user := User{}
-		err = rows.Scan(&(user.Id), &(user.FirstName), &(user.LastName), &(user.Age), &(user.Gender), &(user.NickName), &(user.Email), &(user.Password), &(user.SessionId))
+		user, err := scanUser(rows)
 		if err != nil {
 			return err
 		}
-		fmt.Println(user)
+		fmt.Println(*user)
 	}
 	err = rows.Err()
 	if err != nil {
@@ -88,21 +138,20 @@ func printUsers() error {
 }
 
 func getUserByEmailOrNickNameAndPassword(user User) (*User, error) {
-	u := User{}
 
 	// Get By Email
-	rows, err := db.Query("SELECT * FROM users WHERE email = ?", strings.ToLower(strings.TrimSpace(user.NickName)))
+	rows, err := db.Query("SELECT "+userColumns+" FROM users WHERE email = ?", strings.ToLower(strings.TrimSpace(user.NickName)))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&(u.Id), &(u.FirstName), &(u.LastName), &(u.Age), &(u.Gender), &(u.NickName), &(u.Email), &(u.Password), &(u.SessionId))
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		if compairPasswords(u.Password, user.Password) {
-			return &u, nil
+			return u, nil
 		}
 	}
 	err = rows.Err()
@@ -112,18 +161,18 @@ func getUserByEmailOrNickNameAndPassword(user User) (*User, error) {
 
 	// Get By Nick Name
 
-	rows, err = db.Query("SELECT * FROM users WHERE nick_name = ?", strings.TrimSpace(user.NickName))
+	rows, err = db.Query("SELECT "+userColumns+" FROM users WHERE nick_name = ?", strings.TrimSpace(user.NickName))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	for rows.Next() {
-		err = rows.Scan(&(u.Id), &(u.FirstName), &(u.LastName), &(u.Age), &(u.Gender), &(u.NickName), &(u.Email), &(u.Password), &(u.SessionId))
+		u, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
 		if compairPasswords(u.Password, user.Password) {
-			return &u, nil
+			return u, nil
 		}
 	}
 	err = rows.Err()
@@ -163,15 +212,14 @@ func getUserBySessionId(session_id string) (*User, error) {
 	if strings.TrimSpace(session_id) == "" {
 		return nil, nil
 	}
-	rows, err := db.Query("SELECT * FROM users WHERE session_id = ? LIMIT 1", session_id)
+	rows, err := db.Query("SELECT "+userColumns+" FROM users WHERE session_id = ? LIMIT 1", session_id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var user *User = nil
 	for rows.Next() {
-		user = &User{}
-		err = rows.Scan(&(user.Id), &(user.FirstName), &(user.LastName), &(user.Age), &(user.Gender), &(user.NickName), &(user.Email), &(user.Password), &(user.SessionId))
+		user, err = scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -180,5 +228,220 @@ func getUserBySessionId(session_id string) (*User, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Sessions of banned users are not valid
+	if user != nil {
+		ban, err := getActiveSanction(user.Id, SANCTION_BAN)
+		if err != nil {
+			return nil, err
+		}
+		if ban != nil {
+			return nil, nil
+		}
+	}
 	return user, nil
 }
+
+func resetUserSession(userId int) error {
+	statement, err := db.Prepare("UPDATE users SET session_id = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec("", userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func getUserById(id int) (*User, error) {
+	rows, err := db.Query("SELECT "+userColumns+" FROM users WHERE id = ? LIMIT 1", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var user *User = nil
+	for rows.Next() {
+		user, err = scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func updateUser(user *User) error {
+	statement, err := db.Prepare("UPDATE users SET first_name = ?, last_name = ?, age = ?, gender = ?, nick_name = ?, email = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(user.FirstName, user.LastName, user.Age, user.Gender, user.NickName, strings.ToLower(user.Email), user.Id)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func updatePassword(userId int, password string) error {
+	statement, err := db.Prepare("UPDATE users SET password = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(password, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func getUserByEmailOrNickName(name string) (*User, error) {
+	name = strings.TrimSpace(name)
+	rows, err := db.Query("SELECT "+userColumns+" FROM users WHERE email = ? OR nick_name = ? LIMIT 1", strings.ToLower(name), name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var user *User = nil
+	for rows.Next() {
+		user, err = scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func setVerified(userId int, verified bool) error {
+	statement, err := db.Prepare("UPDATE users SET verified = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(verified, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Remember when verification email was sent. Returns false if previous email
+// was sent less than interval milliseconds ago, in that case nothing is updated
+func updateVerificationSent(userId int, interval int64) (bool, error) {
+	now := getCurrentMilli()
+	result, err := db.Exec("UPDATE users SET verification_sent = ? WHERE id = ? AND verification_sent <= ?", now, userId, now-interval)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func isNickNameTaken(nickName string) (bool, error) {
+	rows, err := db.Query("SELECT id FROM users WHERE nick_name = ? LIMIT 1", nickName)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	taken := rows.Next()
+	err = rows.Err()
+	if err != nil {
+		return false, err
+	}
+	return taken, nil
+}
+
+// All users with roles, for administrators
+func getUsersWithRoles() ([]*User, error) {
+	rows, err := db.Query("SELECT id, nick_name, email, role, verified FROM users ORDER BY nick_name COLLATE NOCASE ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user := User{}
+		err = rows.Scan(&(user.Id), &(user.NickName), &(user.Email), &(user.Role), &(user.Verified))
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func updateRole(userId int, role string) error {
+	statement, err := db.Prepare("UPDATE users SET role = ? WHERE id = ?")
+	if err != nil {
+		return err
+	}
+	defer statement.Close()
+	_, err = statement.Exec(role, userId)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func countUsersWithRole(role string) (int, error) {
+	count := 0
+	err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role = ?", role).Scan(&count)
+	return count, err
+}
+
+// Returns id of user with exactly this nick name, 0 if there is none
+func getUserIdByNickName(nickName string) (int, error) {
+	rows, err := db.Query("SELECT id FROM users WHERE nick_name = ? LIMIT 1", nickName)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	id := 0
+	for rows.Next() {
+		err = rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// Content hash of avatar of user, empty if there is no uploaded avatar
+func getAvatar(userId int) (string, bool, error) {
+	var avatar string
+	err := db.QueryRow("SELECT avatar FROM users WHERE id = ?", userId).Scan(&avatar)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return avatar, true, nil
+}
+
+func updateAvatar(userId int, avatar string) error {
+	_, err := db.Exec("UPDATE users SET avatar = ? WHERE id = ?", avatar, userId)
+	return err
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Add column to existing table. Does nothing if column is already there
+func addColumn(table string, column string, definition string) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%v)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	exists := false
+	for rows.Next() {
+		var cid int
+		var name string
+		var columnType string
+		var notNull int
+		var defaultValue interface{}
+		var pk int
+		err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", table, column, definition))
+	return err
+}
+
+// Placeholders of IN clause for values. No values give (NULL), which matches nothing
+func inClause(values []interface{}) string {
+	if len(values) == 0 {
+		return "(NULL)"
+	}
+	return "(" + strings.TrimSuffix(strings.Repeat("?,", len(values)), ",") + ")"
+}
+
+func intArgs(ids []int) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
+
+// Totals shown by stats command
+func getStats() (*Stats, error) {
+	sql := `
+	SELECT
+	(SELECT COUNT(*) FROM users),
+	(SELECT COUNT(*) FROM users WHERE role = ?),
+	(SELECT COUNT(*) FROM users WHERE role = ?),
+	(SELECT COUNT(*) FROM users WHERE verified = 0),
+	(SELECT COUNT(*) FROM users WHERE session_id != ''),
+	(SELECT COUNT(*) FROM posts),
+	(SELECT COUNT(*) FROM comments),
+	(SELECT COUNT(*) FROM messages),
+	(SELECT COUNT(*) FROM categories),
+	(SELECT COUNT(*) FROM reports WHERE status = ?),
+	(SELECT COUNT(*) FROM sanctions WHERE revoked = 0 AND (expires = 0 OR expires > ?))
+	`
+	stats := Stats{}
+	err := db.QueryRow(sql, ROLE_ADMIN, ROLE_MODERATOR, REPORT_OPEN, getCurrentMilli()).Scan(
+		&(stats.Users), &(stats.Admins), &(stats.Moderators), &(stats.Unverified), &(stats.Sessions),
+		&(stats.Posts), &(stats.Comments), &(stats.Messages), &(stats.Categories),
+		&(stats.OpenReports), &(stats.ActiveSanctions))
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// Earliest publish_at of scheduled posts and messages, 0 if nothing is scheduled
+func getNextPublishAt() (int64, error) {
+	var next int64
+	err := db.QueryRow("SELECT COALESCE(MIN(publish_at), 0) FROM (SELECT publish_at FROM posts WHERE publish_at > 0 UNION ALL SELECT publish_at FROM messages WHERE publish_at > 0)").Scan(&next)
+	return next, err
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Draft targets. Comment and message targets are followed by id of post or chat mate
+const DRAFT_POST = "post"
+const DRAFT_COMMENT = "comment"
+const DRAFT_MESSAGE = "message"
+
+// How often expired drafts are deleted
+const DRAFT_CLEANUP_INTERVAL = time.Hour
+
+func commentDraftTarget(postId int) string {
+	return fmt.Sprintf("%v:%v", DRAFT_COMMENT, postId)
+}
+
+func messageDraftTarget(userId int) string {
+	return fmt.Sprintf("%v:%v", DRAFT_MESSAGE, userId)
+}
+
+// Kind of draft target, or empty string if target is not valid
+func draftKind(target string) string {
+	if target == DRAFT_POST {
+		return DRAFT_POST
+	}
+	parts := strings.SplitN(target, ":", 2)
+	if len(parts) != 2 || (parts[0] != DRAFT_COMMENT && parts[0] != DRAFT_MESSAGE) {
+		return ""
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || id <= 0 || strconv.Itoa(id) != parts[1] {
+		return ""
+	}
+	return parts[0]
+}
+
+// Drafts saved before this are expired. 0 if drafts never expire
+func draftsSince() int64 {
+	if config.DraftTTL <= 0 {
+		return 0
+	}
+	return getCurrentMilli() - int64(config.DraftTTL)*24*60*60*1000
+}
+
+// Delete expired drafts now and then. Meant to run in its own goroutine
+func expireDrafts() {
+	for {
+		if config.DraftTTL > 0 {
+			err := deleteDraftsBefore(draftsSince())
+			if err != nil {
+				errorHandler(err)
+			}
+		}
+		time.Sleep(DRAFT_CLEANUP_INTERVAL)
+	}
+}
+
+// Draft is gone once content is published
+func removeDraft(userId int, target string) {
+	err := deleteDraft(userId, target)
+	if err != nil {
+		errorHandler(err)
+	}
+}
+
+// Same limits as for publishing, so that draft can always be published
+func validateDraft(draft *Draft) *Error {
+	kind := draftKind(draft.Target)
+	if kind == "" {
+		return &Error{Type: INVALID_INPUT, Message: "Error: target should be post, comment:<post_id> or message:<user_id>"}
+	}
+	if kind != DRAFT_POST && (draft.Title != "" || len(draft.Categories) > 0) {
+		return &Error{Type: INVALID_INPUT, Message: "Error: only post drafts have title and categories"}
+	}
+	if len(draft.Title) > 200 {
+		return &Error{Type: INVALID_INPUT, Message: "Title is too large"}
+	}
+	if (kind == DRAFT_MESSAGE && len(draft.Content) > 1000) || len(draft.Content) > 10000 {
+		return &Error{Type: INVALID_INPUT, Message: "Draft is too large"}
+	}
+	return nil
+}
+
+// Drafts of signed in user, or only draft of target if it is given.
+// Payload is null if there is no draft for target
+func draftsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var err error
+	target := r.FormValue("target")
+	if target == "" {
+		resp.Payload, err = getDrafts(user.Id, draftsSince())
+	} else {
+		var draft *Draft
+		draft, err = getDraft(user.Id, target, draftsSince())
+		if draft != nil {
+			resp.Payload = draft
+		}
+	}
+	if err != nil {
+		resp.Payload = nil
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Autosave draft of target. Without version the stored draft is overwritten.
+// With version, which is 0 for new draft, draft is saved only if stored draft
+// still has that version, otherwise DRAFT_CONFLICT is returned with stored draft
+// as payload. Saving empty draft deletes it
+func saveDraftHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	draft := Draft{
+		Target:     r.FormValue("target"),
+		Title:      strings.TrimSpace(r.FormValue("title")),
+		Content:    r.FormValue("content"),
+		Categories: []string{},
+	}
+	if r.FormValue("categories") != "" {
+		err := json.Unmarshal([]byte(r.FormValue("categories")), &(draft.Categories))
+		if err != nil || draft.Categories == nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: "Error: categories should be JSON array of strings"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+	version := -1
+	if r.FormValue("version") != "" {
+		var err error
+		version, err = strconv.Atoi(r.FormValue("version"))
+		if err != nil || version < 0 {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: "Error: invalid version"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	resp.Error = validateDraft(&draft)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if strings.TrimSpace(draft.Content) == "" && draft.Title == "" && len(draft.Categories) == 0 {
+		err := deleteDraft(user.Id, draft.Target)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Expired draft that is not deleted yet does not count, whatever version is given
+	err := deleteDraftsBefore(draftsSince())
+	if err != nil {
+		errorHandler(err)
+	}
+
+	saved, err := saveDraft(user.Id, &draft, version)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	stored, err := getDraft(user.Id, draft.Target, 0)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !saved {
+		resp.Error = &Error{Type: DRAFT_CONFLICT, Message: "Error: draft was changed elsewhere"}
+	}
+	if stored != nil {
+		resp.Payload = stored
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func deleteDraftHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	target := r.FormValue("target")
+	if draftKind(target) == "" {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: target should be post, comment:<post_id> or message:<user_id>"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := deleteDraft(user.Id, target)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
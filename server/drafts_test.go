@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func postSaveDraft(t *testing.T, user *User, content string, version string) (*Draft, *Error) {
+	t.Helper()
+	form := url.Values{"target": {"post"}, "content": {content}, "version": {version}}
+	r := httptest.NewRequest("POST", "/savedraft", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	saveDraftHandler(w, r, user)
+
+	resp := struct {
+		Payload *Draft `json:"payload"`
+		Error   *Error `json:"error"`
+	}{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Payload, resp.Error
+}
+
+// Make draft look like it was last saved before drafts expire
+func expireDraft(t *testing.T, user *User) {
+	t.Helper()
+	_, err := db.Exec("UPDATE drafts SET date = ? WHERE user_id = ?", draftsSince()-1000, user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSaveDraftVersions(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "drafter")
+
+	draft, e := postSaveDraft(t, user, "first", "0")
+	if e != nil || draft == nil || draft.Version != 1 {
+		t.Fatalf("new draft: %+v %+v", draft, e)
+	}
+	draft, e = postSaveDraft(t, user, "second", "1")
+	if e != nil || draft.Version != 2 || draft.Content != "second" {
+		t.Fatalf("next version: %+v %+v", draft, e)
+	}
+
+	// Stale version gets stored draft back
+	draft, e = postSaveDraft(t, user, "stale", "1")
+	if e == nil || e.Type != DRAFT_CONFLICT || draft == nil || draft.Content != "second" {
+		t.Fatalf("stale version: %+v %+v", draft, e)
+	}
+	draft, e = postSaveDraft(t, user, "other tab", "0")
+	if e == nil || e.Type != DRAFT_CONFLICT || draft == nil || draft.Content != "second" {
+		t.Fatalf("new draft over stored one: %+v %+v", draft, e)
+	}
+}
+
+func TestSaveDraftOverExpired(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "returner")
+
+	_, e := postSaveDraft(t, user, "old", "0")
+	if e != nil {
+		t.Fatal(e.Message)
+	}
+	expireDraft(t, user)
+
+	// Expired draft is not shown, so new draft starts from version 0
+	draft, e := postSaveDraft(t, user, "new", "0")
+	if e != nil || draft == nil || draft.Version != 1 || draft.Content != "new" {
+		t.Fatalf("new draft over expired one: %+v %+v", draft, e)
+	}
+
+	// Version of expired draft does not match anymore
+	expireDraft(t, user)
+	draft, e = postSaveDraft(t, user, "revived", "1")
+	if e == nil || e.Type != DRAFT_CONFLICT || draft != nil {
+		t.Fatalf("version of expired draft: %+v %+v", draft, e)
+	}
+}
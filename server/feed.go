@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Feed event types
+const FEED_POST_CREATED = "post_created"
+const FEED_POST_DELETED = "post_deleted"
+const FEED_POST_UPDATED = "post_updated"
+const FEED_COMMENT_CREATED = "comment_created"
+const FEED_COMMENT_DELETED = "comment_deleted"
+const FEED_LINK_PREVIEW = "link_preview"
+const FEED_POLL_UPDATED = "poll_updated"
+
+// What part of feed client is looking at. Set by client through websocket:
+//
+//	{"subscribe": {"categories": ["kivi"]}}  home feed filtered by categories, all categories if empty
+//	{"subscribe": {"post_id": 5}}            comments of post 5
+//	{"unsubscribe": true}                    no feed events
+type FeedSubscription struct {
+	PostId     int      `json:"post_id"`
+	Categories []string `json:"categories"`
+}
+
+// Command sent by client through websocket
+type ClientCommand struct {
+	Subscribe   *FeedSubscription `json:"subscribe"`
+	Unsubscribe bool              `json:"unsubscribe"`
+}
+
+type FeedEvent struct {
+	Type      string   `json:"type"`
+	PostId    int      `json:"post_id"`
+	CommentId int      `json:"comment_id,omitempty"`
+	Post      *Post    `json:"post,omitempty"`
+	Comment   *Comment `json:"comment,omitempty"`
+
+	// Preview of link in post, or in comment if CommentId is set
+	LinkPreview *LinkPreview `json:"link_preview,omitempty"`
+
+	// New results of poll of post
+	Poll *Poll `json:"poll,omitempty"`
+
+	// Categories of post, used to find home feed subscribers
+	categories []string
+	// Event is sent only to clients viewing post, not to home feed
+	postOnly bool
+}
+
+type FeedEventWrapper struct {
+	FeedEvent FeedEvent `json:"feed_event"`
+}
+
+func handleClientCommand(client *Client, message []byte) {
+	command := ClientCommand{}
+	err := json.Unmarshal(message, &command)
+	if err != nil {
+		fmt.Println("Unknown client message: ", string(message))
+		return
+	}
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if command.Unsubscribe {
+		client.feed = nil
+	} else if command.Subscribe != nil {
+		client.feed = command.Subscribe
+	}
+}
+
+// Client viewing post gets events of that post only,
+// client on home feed gets events of posts in its categories
+func isSubscribed(client *Client, event *FeedEvent) bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if client.feed == nil {
+		return false
+	}
+	if client.feed.PostId != 0 {
+		return client.feed.PostId == event.PostId
+	}
+	if event.postOnly {
+		return false
+	}
+	if len(client.feed.Categories) == 0 {
+		return true
+	}
+	for _, category := range event.categories {
+		if containsString(client.feed.Categories, category) {
+			return true
+		}
+	}
+	return false
+}
+
+func publishFeedEvent(event *FeedEvent) {
+	if event == nil {
+		return
+	}
+	b, err := json.Marshal(FeedEventWrapper{*event})
+	if err != nil {
+		errorHandler(err)
+		return
+	}
+	for _, client := range connectedClients() {
+		if isSubscribed(client, event) {
+			client.send(b)
+		}
+	}
+}
+
+func postCreatedEvent(post *Post) *FeedEvent {
+	return &FeedEvent{Type: FEED_POST_CREATED, PostId: post.Id, Post: post, categories: post.Categories}
+}
+
+// Post was pinned, unpinned, locked or unlocked
+func postUpdatedEvent(post *Post) *FeedEvent {
+	return &FeedEvent{Type: FEED_POST_UPDATED, PostId: post.Id, Post: post, categories: post.Categories}
+}
+
+func commentCreatedEvent(post *Post, comment *Comment) *FeedEvent {
+	return &FeedEvent{Type: FEED_COMMENT_CREATED, PostId: post.Id, CommentId: comment.Id, Comment: comment, categories: post.Categories}
+}
+
+// Results change with every vote, so they go only to clients viewing post
+func pollUpdatedEvent(post *Post, poll *Poll) *FeedEvent {
+	return &FeedEvent{Type: FEED_POLL_UPDATED, PostId: post.Id, Poll: poll, categories: post.Categories, postOnly: true}
+}
+
+// Event about post or comment that is going to be deleted or hidden.
+// Has to be built before removal, nil if content is not visible in feed
+func removalEvent(contentType string, contentId int) *FeedEvent {
+	event := FeedEvent{PostId: contentId}
+	switch contentType {
+	case CONTENT_POST:
+		event.Type = FEED_POST_DELETED
+	case CONTENT_COMMENT:
+		comment, err := getComment(contentId)
+		if err != nil || comment == nil {
+			return nil
+		}
+		event.Type = FEED_COMMENT_DELETED
+		event.PostId = comment.PostId
+		event.CommentId = comment.Id
+	default:
+		return nil
+	}
+	post, err := getPost(event.PostId)
+	if err != nil || post.Id == 0 {
+		return nil
+	}
+	event.categories = post.Categories
+	return &event
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Value of feed parameter of home page for posts of followed users and categories
+const FOLLOWING_FEED = "following"
+
+// User user_id of request, who has to exist and not be user
+func followTargetFromForm(r *http.Request, user *User) (*User, *Error) {
+	userId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		return nil, &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if userId == user.Id {
+		return nil, &Error{Type: INVALID_INPUT, Message: "Error: you cannot follow yourself"}
+	}
+	target, err := getUserById(userId)
+	if err != nil {
+		return nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if target == nil {
+		return nil, &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+	}
+	return target, nil
+}
+
+// Follow user_id. Followed user is notified the first time.
+// Users cannot follow each other if either one blocked the other
+func followHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	target, e := followTargetFromForm(r, user)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	blocked, err := isBlocked(target.Id, user.Id)
+	if err == nil && !blocked {
+		blocked, err = isBlocked(user.Id, target.Id)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if blocked {
+		resp.Error = &Error{Type: USER_BLOCKED, Message: "Error: you cannot follow this user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	inserted, err := insertFollow(user.Id, target.Id)
+	// Following again after unfollow is not notified
+	first := false
+	if err == nil && inserted {
+		first, err = insertFollowNotification(user.Id, target.Id)
+	}
+	if err == nil && first {
+		err = notify(Notification{
+			UserId:        target.Id,
+			Type:          NOTIFICATION_FOLLOW,
+			ActorId:       user.Id,
+			ActorNickName: user.NickName,
+		})
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func unfollowHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = deleteFollow(user.Id, userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Follow existing category
+func followCategoryHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	category := r.FormValue("category")
+	categories, err := getCategories()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !containsString(categories, category) {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: no such category"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = insertCategoryFollow(user.Id, category)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func unfollowCategoryHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := deleteCategoryFollow(user.Id, r.FormValue("category"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Users and categories followed by signed in user
+func followingHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	following := Following{}
+	var err error
+	following.Users, err = getFollowUsers(user.Id, false)
+	if err == nil {
+		following.Categories, err = getFollowedCategories(user.Id)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = following
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Followers of user_id, or of signed in user if user_id is not given
+func followersHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId := user.Id
+	if r.FormValue("user_id") != "" {
+		var err error
+		userId, err = strconv.Atoi(r.FormValue("user_id"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	followers, err := getFollowUsers(userId, true)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = followers
+	json.NewEncoder(w).Encode(resp)
+}
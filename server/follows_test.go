@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func postFollow(t *testing.T, handler func(http.ResponseWriter, *http.Request, *User), user *User, target *User) {
+	t.Helper()
+	form := url.Values{"user_id": {strconv.Itoa(target.Id)}}
+	r := httptest.NewRequest("POST", "/follow", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r, user)
+
+	resp := Response{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil {
+		t.Fatal(resp.Error.Message)
+	}
+}
+
+func TestFollowNotifiedOnce(t *testing.T) {
+	setupTestDB(t)
+	follower := createTestUser(t, "follower")
+	followed := createTestUser(t, "followed")
+
+	for i := 0; i < 3; i++ {
+		postFollow(t, followHandler, follower, followed)
+		postFollow(t, followHandler, follower, followed)
+		postFollow(t, unfollowHandler, follower, followed)
+	}
+	postFollow(t, followHandler, follower, followed)
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM notifications WHERE user_id = ? AND type = ?", followed.Id, NOTIFICATION_FOLLOW).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("followed user notified %v times", count)
+	}
+}
+
+func TestFollowedCanMessageContactsOnly(t *testing.T) {
+	setupTestDB(t)
+	recipient := createTestUser(t, "private")
+	followed := createTestUser(t, "followed")
+	stranger := createTestUser(t, "stranger")
+	err := savePrivacy(recipient.Id, &Privacy{ContactsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, e := checkCanMessage(followed, recipient.Id)
+	if e != nil || !pending {
+		t.Fatalf("before follow: pending %v, %+v", pending, e)
+	}
+	postFollow(t, followHandler, recipient, followed)
+
+	pending, e = checkCanMessage(followed, recipient.Id)
+	if e != nil || pending {
+		t.Errorf("followed user: pending %v, %+v", pending, e)
+	}
+	// Following is one way
+	err = savePrivacy(followed.Id, &Privacy{ContactsOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending, e = checkCanMessage(recipient, followed.Id)
+	if e != nil || !pending {
+		t.Errorf("follower: pending %v, %+v", pending, e)
+	}
+	pending, e = checkCanMessage(stranger, recipient.Id)
+	if e != nil || !pending {
+		t.Errorf("stranger: pending %v, %+v", pending, e)
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+)
+
+var (
+	testRed  = color.RGBA{255, 0, 0, 255}
+	testBlue = color.RGBA{0, 0, 255, 255}
+)
+
+// Image with left half red and right half blue
+func halvesImage(w int, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x < w/2 {
+				img.SetRGBA(x, y, testRed)
+			} else {
+				img.SetRGBA(x, y, testBlue)
+			}
+		}
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, img image.Image, mimeType string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var err error
+	switch mimeType {
+	case "image/jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95})
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// JPEG with EXIF segment right after start of image marker, holding
+// orientation and text that stands for camera location
+func withExif(jpegData []byte, orientation int, secret string) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	entry := make([]byte, 2+12+4)
+	binary.LittleEndian.PutUint16(entry[0:], 1)
+	binary.LittleEndian.PutUint16(entry[2:], 0x0112)
+	binary.LittleEndian.PutUint16(entry[4:], 3)
+	binary.LittleEndian.PutUint32(entry[6:], 1)
+	binary.LittleEndian.PutUint16(entry[10:], uint16(orientation))
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, secret...)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	header := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(header[2:], uint16(len(segment)+2))
+
+	data := append([]byte{}, jpegData[:2]...)
+	data = append(data, header...)
+	data = append(data, segment...)
+	return append(data, jpegData[2:]...)
+}
+
+func isReddish(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r > 0xC000 && g < 0x4000 && b < 0x4000
+}
+
+func isBluish(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return b > 0xC000 && r < 0x4000 && g < 0x4000
+}
+
+func TestCleanImageStripsExif(t *testing.T) {
+	data := withExif(encodeTestImage(t, halvesImage(32, 16), "image/jpeg"), 1, "GPS 59.437N 24.745E")
+	if !bytes.Contains(data, []byte("Exif")) || !bytes.Contains(data, []byte("GPS")) {
+		t.Fatal("test image has no EXIF")
+	}
+
+	clean, err := cleanImage(data, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(clean.Data, []byte("Exif")) || bytes.Contains(clean.Data, []byte("GPS")) {
+		t.Error("EXIF left in image")
+	}
+	if bytes.Contains(clean.Thumbnail, []byte("Exif")) || bytes.Contains(clean.Thumbnail, []byte("GPS")) {
+		t.Error("EXIF left in thumbnail")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(clean.Data)); err != nil {
+		t.Errorf("cleaned image does not decode: %v", err)
+	}
+}
+
+func TestCleanImageAppliesOrientation(t *testing.T) {
+	// Orientation 6 means camera was turned, image is shown rotated 90 degrees clockwise,
+	// so left half ends up on top
+	data := withExif(encodeTestImage(t, halvesImage(32, 16), "image/jpeg"), 6, "")
+	clean, err := cleanImage(data, "image/jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clean.Width != 16 || clean.Height != 32 {
+		t.Fatalf("size %vx%v, want 16x32", clean.Width, clean.Height)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(clean.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 16 || img.Bounds().Dy() != 32 {
+		t.Fatalf("stored size %v", img.Bounds())
+	}
+	if !isReddish(img.At(8, 4)) || !isBluish(img.At(8, 28)) {
+		t.Errorf("not rotated: top %v, bottom %v", img.At(8, 4), img.At(8, 28))
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	// 3x2 image with marked top left pixel
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.SetRGBA(0, 0, testRed)
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		x           int
+		y           int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+	for _, test := range tests {
+		img := orientImage(src, test.orientation)
+		if img.Bounds().Dx() != test.width || img.Bounds().Dy() != test.height {
+			t.Errorf("orientation %v: size %v", test.orientation, img.Bounds())
+			continue
+		}
+		if img.At(test.x, test.y) != color.Color(testRed) {
+			t.Errorf("orientation %v: marked pixel not at %v,%v", test.orientation, test.x, test.y)
+		}
+	}
+}
+
+func TestCleanImageThumbnailSize(t *testing.T) {
+	tests := []struct {
+		mimeType      string
+		width         int
+		height        int
+		thumbWidth    int
+		thumbHeight   int
+		thumbnailType string
+	}{
+		{"image/png", 1000, 500, THUMBNAIL_SIZE, 160, "png"},
+		{"image/jpeg", 200, 800, 80, THUMBNAIL_SIZE, "jpeg"},
+		{"image/gif", 640, 640, THUMBNAIL_SIZE, THUMBNAIL_SIZE, "png"},
+		{"image/png", 100, 50, 100, 50, "png"},
+	}
+	for _, test := range tests {
+		data := encodeTestImage(t, halvesImage(test.width, test.height), test.mimeType)
+		clean, err := cleanImage(data, test.mimeType)
+		if err != nil {
+			t.Fatalf("%v %vx%v: %v", test.mimeType, test.width, test.height, err)
+		}
+		if clean.Width != test.width || clean.Height != test.height {
+			t.Errorf("%v %vx%v: size %vx%v", test.mimeType, test.width, test.height, clean.Width, clean.Height)
+		}
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(clean.Thumbnail))
+		if err != nil {
+			t.Fatalf("%v %vx%v: thumbnail %v", test.mimeType, test.width, test.height, err)
+		}
+		if cfg.Width != test.thumbWidth || cfg.Height != test.thumbHeight || format != test.thumbnailType {
+			t.Errorf("%v %vx%v: thumbnail %v %vx%v", test.mimeType, test.width, test.height, format, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestCleanImageRejectsInvalid(t *testing.T) {
+	pngData := encodeTestImage(t, halvesImage(8, 8), "image/png")
+	if _, err := cleanImage(pngData[:len(pngData)/2], "image/png"); err == nil {
+		t.Error("truncated image accepted")
+	}
+	if _, err := cleanImage([]byte("GIF89a not really"), "image/gif"); err == nil {
+		t.Error("fake GIF accepted")
+	}
+	// Decoder is chosen by sniffed type
+	if _, err := cleanImage(pngData, "image/jpeg"); err == nil {
+		t.Error("PNG decoded as JPEG")
+	}
+}
+
+type testFile struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+func parseTestUploads(t *testing.T, files ...testFile) ([]*Upload, *Error) {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="attachments"; filename="`+file.name+`"`)
+		header.Set("Content-Type", file.contentType)
+		part, err := form.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write(file.data)
+	}
+	form.Close()
+	r := httptest.NewRequest("POST", "/post", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	return parseAttachments(httptest.NewRecorder(), r)
+}
+
+func TestParseAttachmentsSniffsType(t *testing.T) {
+	pngData := encodeTestImage(t, halvesImage(8, 8), "image/png")
+
+	// Declared type and name do not matter
+	uploads, e := parseTestUploads(t, testFile{"photo.jpg", "image/jpeg", pngData}, testFile{"notes.png", "image/png", []byte("just some notes\n")})
+	if e != nil {
+		t.Fatal(e.Message)
+	}
+	if uploads[0].MimeType != "image/png" || uploads[0].Image == nil {
+		t.Errorf("png declared as jpeg: %v", uploads[0].MimeType)
+	}
+	if uploads[1].MimeType != "text/plain" || uploads[1].Image != nil {
+		t.Errorf("text declared as png: %v", uploads[1].MimeType)
+	}
+
+	// HTML is not allowed whatever it claims to be
+	_, e = parseTestUploads(t, testFile{"cat.gif", "image/gif", []byte("<html><script>alert(1)</script></html>")})
+	if e == nil || e.Type != INVALID_INPUT {
+		t.Errorf("html declared as gif: %+v", e)
+	}
+
+	// Content sniffed as image has to be valid image
+	_, e = parseTestUploads(t, testFile{"broken.png", "image/png", pngData[:40]})
+	if e == nil || e.Type != INVALID_INPUT {
+		t.Errorf("broken png: %+v", e)
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// At most this many links of one post, comment or message get preview
+const MAX_LINK_PREVIEWS = 3
+
+// Cached previews are fetched again after this many milliseconds
+const LINK_PREVIEW_TTL = 24 * 60 * 60 * 1000
+
+// Only this many bytes of linked page are read
+const LINK_PREVIEW_MAX_BODY = 512 * 1024
+
+// Lengths of texts kept from page
+const LINK_PREVIEW_TITLE_LENGTH = 200
+const LINK_PREVIEW_DESCRIPTION_LENGTH = 300
+
+var (
+	linkRegexp      = regexp.MustCompile(`https?://[^\s<>"'` + "`" + `]+`)
+	metaTagRegexp   = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	titleTagRegexp  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	headEndRegexp   = regexp.MustCompile(`(?i)</head>`)
+	attributeRegexp = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+)
+
+// Addresses previews are never fetched from: loopback, private networks,
+// link local (including cloud metadata service), multicast and reserved ranges
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "64:ff9b::/96", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+var errForbiddenAddress = errors.New("link preview: address is not allowed")
+
+var linkPreviewClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		// No proxy, dialed address is the one that is checked
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 3 * time.Second,
+			Control: checkDialAddress,
+		}).DialContext,
+		TLSHandshakeTimeout:    3 * time.Second,
+		ResponseHeaderTimeout:  3 * time.Second,
+		MaxResponseHeaderBytes: 64 * 1024,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 3 {
+			return errors.New("link preview: too many redirects")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errForbiddenAddress
+		}
+		return nil
+	},
+}
+
+// URLs being fetched, so that the same link posted twice is fetched once.
+// Channel is closed when fetch is done
+var linkPreviewMutex sync.Mutex
+var linkPreviewsFetching = map[string]chan bool{}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+func isPrivateIp(ip net.IP) bool {
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Called with resolved address before connecting, also for redirects
+func checkDialAddress(network string, address string, c syscall.RawConn) error {
+	if config.LinkPreviewAllowPrivate {
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivateIp(ip) || (port != "80" && port != "443") {
+		return errForbiddenAddress
+	}
+	return nil
+}
+
+// Distinct http(s) links in content, without trailing punctuation
+func findLinks(content string) []string {
+	links := []string{}
+	for _, link := range linkRegexp.FindAllString(content, -1) {
+		link = strings.TrimRight(link, ".,;:!?*_~")
+		// Closing parenthesis belongs to link only if it has opening one, as in Wikipedia links
+		for strings.HasSuffix(link, ")") && strings.Count(link, "(") < strings.Count(link, ")") {
+			link = strings.TrimSuffix(link, ")")
+		}
+		u, err := url.Parse(link)
+		if err != nil || u.Host == "" || containsString(links, link) {
+			continue
+		}
+		links = append(links, link)
+		if len(links) == MAX_LINK_PREVIEWS {
+			break
+		}
+	}
+	return links
+}
+
+// Cached previews of links in content
+func getLinkPreviews(content string) ([]LinkPreview, error) {
+	previews, err := getLinkPreviewsOf([]string{content})
+	if err != nil {
+		return nil, err
+	}
+	return previews[0], nil
+}
+
+// Cached previews of links in each of contents, looked up at once
+func getLinkPreviewsOf(contents []string) ([][]LinkPreview, error) {
+	links := make([][]string, len(contents))
+	urls := []string{}
+	for i, content := range contents {
+		links[i] = findLinks(content)
+		urls = append(urls, links[i]...)
+	}
+	cached, err := getLinkPreviewsByUrl(urls)
+	if err != nil {
+		return nil, err
+	}
+
+	previews := make([][]LinkPreview, len(contents))
+	for i := range contents {
+		previews[i] = []LinkPreview{}
+		for _, link := range links[i] {
+			if preview, ok := cached[link]; ok && preview.Title != "" {
+				previews[i] = append(previews[i], preview)
+			}
+		}
+	}
+	return previews, nil
+}
+
+// Fetch previews of links in content that are not cached yet, or are cached
+// for too long, and pass each new preview to publish. Meant to run in its own goroutine
+func fetchLinkPreviews(content string, publish func(preview LinkPreview)) {
+	if !config.LinkPreviews {
+		return
+	}
+	for _, link := range findLinks(content) {
+		cached, date, err := getLinkPreview(link)
+		if err != nil {
+			errorHandler(err)
+			continue
+		}
+		if cached != nil && getCurrentMilli()-date < LINK_PREVIEW_TTL {
+			continue
+		}
+
+		linkPreviewMutex.Lock()
+		done, fetching := linkPreviewsFetching[link]
+		if !fetching {
+			done = make(chan bool)
+			linkPreviewsFetching[link] = done
+		}
+		linkPreviewMutex.Unlock()
+
+		var preview *LinkPreview
+		if fetching {
+			// Somebody else is fetching it, use their result
+			<-done
+			preview, _, err = getLinkPreview(link)
+		} else {
+			preview, err = fetchLinkPreview(link)
+			// Failures are cached too, so that broken links are not fetched again and again.
+			// They are not logged, links come from users
+			if err != nil {
+				preview = &LinkPreview{Url: link}
+			}
+			err = saveLinkPreview(preview)
+
+			linkPreviewMutex.Lock()
+			delete(linkPreviewsFetching, link)
+			linkPreviewMutex.Unlock()
+			close(done)
+		}
+		if err != nil {
+			errorHandler(err)
+			continue
+		}
+		if preview == nil {
+			continue
+		}
+		if preview.Title != "" {
+			publish(*preview)
+		}
+	}
+}
+
+func fetchLinkPreview(link string) (*LinkPreview, error) {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "ForumLinkPreview/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := linkPreviewClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("link preview: %v: %v", link, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("link preview: %v: not a html page", link)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, LINK_PREVIEW_MAX_BODY))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := parseLinkPreview(string(body), resp.Request.URL)
+	preview.Url = link
+	return preview, nil
+}
+
+// Read OpenGraph and Twitter card meta tags, falling back to title and description of page
+func parseLinkPreview(page string, pageUrl *url.URL) *LinkPreview {
+	if end := headEndRegexp.FindStringIndex(page); end != nil {
+		page = page[:end[0]]
+	}
+	page = strings.ToValidUTF8(page, "")
+
+	meta := map[string]string{}
+	for _, tag := range metaTagRegexp.FindAllString(page, -1) {
+		attributes := map[string]string{}
+		for _, a := range attributeRegexp.FindAllStringSubmatch(tag, -1) {
+			attributes[strings.ToLower(a[1])] = a[2] + a[3] + a[4]
+		}
+		key := attributes["property"]
+		if key == "" {
+			key = attributes["name"]
+		}
+		key = strings.ToLower(key)
+		if _, ok := meta[key]; key != "" && !ok {
+			meta[key] = cleanPreviewText(attributes["content"])
+		}
+	}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if meta[key] != "" {
+				return meta[key]
+			}
+		}
+		return ""
+	}
+
+	preview := LinkPreview{
+		Title:       first("og:title", "twitter:title"),
+		Description: first("og:description", "twitter:description", "description"),
+		SiteName:    first("og:site_name"),
+	}
+	if preview.Title == "" {
+		if match := titleTagRegexp.FindStringSubmatch(page); match != nil {
+			preview.Title = cleanPreviewText(match[1])
+		}
+	}
+	if preview.SiteName == "" {
+		preview.SiteName = pageUrl.Hostname()
+	}
+	if image := first("og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src"); image != "" {
+		imageUrl, err := pageUrl.Parse(image)
+		if err == nil && (imageUrl.Scheme == "http" || imageUrl.Scheme == "https") {
+			preview.Image = imageUrl.String()
+		}
+	}
+	preview.Title = excerpt(preview.Title, LINK_PREVIEW_TITLE_LENGTH)
+	preview.Description = excerpt(preview.Description, LINK_PREVIEW_DESCRIPTION_LENGTH)
+	preview.SiteName = excerpt(preview.SiteName, LINK_PREVIEW_TITLE_LENGTH)
+	return &preview
+}
+
+// Unescape entities and collapse whitespace
+func cleanPreviewText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// Preview of post or comment goes to clients following the post in feed
+func publishContentLinkPreviews(post *Post, commentId int, content string) {
+	go fetchLinkPreviews(content, func(preview LinkPreview) {
+		publishFeedEvent(&FeedEvent{Type: FEED_LINK_PREVIEW, PostId: post.Id, CommentId: commentId, LinkPreview: &preview, categories: post.Categories})
+	})
+}
+
+// Preview of message goes to sender, and to recipient unless message is a request
+func publishMessageLinkPreviews(message *Message) {
+	go fetchLinkPreviews(message.Content, func(preview LinkPreview) {
+		b, err := json.Marshal(MessageLinkPreviewWrapper{MessageLinkPreview{MessageId: message.Id, LinkPreview: preview}})
+		if err != nil {
+			errorHandler(err)
+			return
+		}
+		notifyClient(message.FromId, b)
+		if !message.Pending {
+			notifyClient(message.ToId, b)
+		}
+	})
+}
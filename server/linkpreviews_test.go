@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Let link previews be fetched from server of test, which listens on loopback
+func allowPrivateLinks(t *testing.T, allow bool) {
+	previous := config
+	config.LinkPreviewAllowPrivate = allow
+	t.Cleanup(func() { config = previous })
+}
+
+func TestParseLinkPreview(t *testing.T) {
+	pageUrl, _ := url.Parse("https://example.com/articles/1")
+	tests := []struct {
+		name string
+		page string
+		want LinkPreview
+	}{
+		{
+			"open graph",
+			`<head><meta property="og:title" content="OG title"><meta property="og:description" content="OG description">
+			<meta property="og:site_name" content="Example"><meta property="og:image" content="https://cdn.example.com/a.png">
+			<title>Page title</title></head>`,
+			LinkPreview{Title: "OG title", Description: "OG description", SiteName: "Example", Image: "https://cdn.example.com/a.png"},
+		},
+		{
+			"twitter card",
+			`<meta name="twitter:title" content="Card title"><meta name="twitter:description" content="Card description">
+			<meta name="twitter:image" content="/card.png">`,
+			LinkPreview{Title: "Card title", Description: "Card description", SiteName: "example.com", Image: "https://example.com/card.png"},
+		},
+		{
+			"title and description",
+			`<html><head><TITLE lang="en">  Plain
+			title </TITLE><meta name="Description" content="About page"></head>`,
+			LinkPreview{Title: "Plain title", Description: "About page", SiteName: "example.com"},
+		},
+		{
+			"first tag wins",
+			`<meta property="og:title" content="First"><meta property="og:title" content="Second">`,
+			LinkPreview{Title: "First", SiteName: "example.com"},
+		},
+		{
+			"attribute quoting",
+			`<meta content='Single "quoted"' property='og:title'><meta property=og:description content=unquoted>`,
+			LinkPreview{Title: `Single "quoted"`, Description: "unquoted", SiteName: "example.com"},
+		},
+		{
+			"entities",
+			`<meta property="og:title" content="Fish &amp; chips &lt;3 &#8212; &quot;best&quot;">`,
+			LinkPreview{Title: `Fish & chips <3 — "best"`, SiteName: "example.com"},
+		},
+		{
+			"relative image",
+			`<meta property="og:image" content="../images/a.png"><title>T</title>`,
+			LinkPreview{Title: "T", SiteName: "example.com", Image: "https://example.com/images/a.png"},
+		},
+		{
+			"javascript image",
+			`<meta property="og:image" content="javascript:alert(1)"><title>T</title>`,
+			LinkPreview{Title: "T", SiteName: "example.com"},
+		},
+		{
+			"data image",
+			`<meta property="og:image" content="data:image/png;base64,AAAA"><title>T</title>`,
+			LinkPreview{Title: "T", SiteName: "example.com"},
+		},
+		{
+			"body is ignored",
+			`<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			LinkPreview{Title: "Head", SiteName: "example.com"},
+		},
+		{
+			"no metadata",
+			`<p>Nothing here</p>`,
+			LinkPreview{SiteName: "example.com"},
+		},
+	}
+	for _, test := range tests {
+		got := *parseLinkPreview(test.page, pageUrl)
+		if got != test.want {
+			t.Errorf("%v:\n got  %+v\n want %+v", test.name, got, test.want)
+		}
+	}
+
+	long := strings.Repeat("é", LINK_PREVIEW_DESCRIPTION_LENGTH+50)
+	got := parseLinkPreview(`<meta name="description" content="`+long+`">`, pageUrl)
+	if got.Description != strings.Repeat("é", LINK_PREVIEW_DESCRIPTION_LENGTH)+"..." {
+		t.Errorf("long description: %q", got.Description)
+	}
+}
+
+func TestFetchLinkPreview(t *testing.T) {
+	allowPrivateLinks(t, true)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<head><meta property="og:title" content="Fetched"><meta property="og:image" content="/i.png"></head>`)
+	})
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/redirect/"), "%d", &n)
+		target := "/page"
+		if n > 1 {
+			target = fmt.Sprintf("/redirect/%d", n-1)
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+	mux.HandleFunc("/scheme", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<head>"+strings.Repeat(" ", LINK_PREVIEW_MAX_BODY)+`<title>Too far</title></head>`)
+	})
+	mux.HandleFunc("/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"title": "<title>Not a page</title>"}`)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, `<svg><title>Not a page</title></svg>`)
+	})
+	mux.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<title>Not found</title>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	preview, err := fetchLinkPreview(server.URL + "/page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	if preview.Title != "Fetched" || preview.Url != server.URL+"/page" || preview.Image != server.URL+"/i.png" || preview.SiteName != strings.Split(host, ":")[0] {
+		t.Errorf("page: %+v", preview)
+	}
+
+	// Two redirects are followed, image is relative to final page
+	preview, err = fetchLinkPreview(server.URL + "/redirect/2")
+	if err != nil || preview.Title != "Fetched" || preview.Url != server.URL+"/redirect/2" || preview.Image != server.URL+"/i.png" {
+		t.Errorf("two redirects: %+v %v", preview, err)
+	}
+	_, err = fetchLinkPreview(server.URL + "/redirect/3")
+	if err == nil || !strings.Contains(err.Error(), "too many redirects") {
+		t.Errorf("three redirects: %v", err)
+	}
+	_, err = fetchLinkPreview(server.URL + "/scheme")
+	if !errors.Is(err, errForbiddenAddress) {
+		t.Errorf("redirect to file: %v", err)
+	}
+
+	// Only start of page is read
+	preview, err = fetchLinkPreview(server.URL + "/large")
+	if err != nil || preview.Title != "" {
+		t.Errorf("large page: %+v %v", preview, err)
+	}
+
+	for _, path := range []string{"/json", "/image", "/missing"} {
+		preview, err = fetchLinkPreview(server.URL + path)
+		if err == nil {
+			t.Errorf("%v: got %+v", path, preview)
+		}
+	}
+}
+
+func TestFetchLinkPreviewRefusesPrivateAddress(t *testing.T) {
+	allowPrivateLinks(t, false)
+	fetched := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched = true
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Internal</title>`)
+	}))
+	defer server.Close()
+
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+	for _, link := range []string{server.URL, "http://localhost:" + port + "/"} {
+		_, err := fetchLinkPreview(link)
+		if !errors.Is(err, errForbiddenAddress) {
+			t.Errorf("%v: %v", link, err)
+		}
+	}
+	if fetched {
+		t.Error("private address was fetched")
+	}
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	allowPrivateLinks(t, false)
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{"93.184.216.34:80", true},
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1::]:443", true},
+		{"93.184.216.34:8080", false},
+		{"93.184.216.34:22", false},
+		{"127.0.0.1:80", false},
+		{"10.1.2.3:443", false},
+		{"172.16.0.1:80", false},
+		{"192.168.1.1:80", false},
+		{"100.64.0.1:80", false},
+		{"169.254.169.254:80", false},
+		{"0.0.0.0:80", false},
+		{"224.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"[::]:80", false},
+		{"[::ffff:127.0.0.1]:80", false},
+		{"[::ffff:169.254.169.254]:80", false},
+		{"[64:ff9b::a00:1]:80", false},
+		{"[fd00::1]:443", false},
+		{"[fe80::1]:443", false},
+		{"not an address", false},
+	}
+	for _, test := range tests {
+		err := checkDialAddress("tcp", test.address, nil)
+		if (err == nil) != test.allowed {
+			t.Errorf("%v: allowed %v, got %v", test.address, test.allowed, err)
+		}
+	}
+
+	allowPrivateLinks(t, true)
+	if err := checkDialAddress("tcp", "127.0.0.1:8080", nil); err != nil {
+		t.Errorf("private addresses allowed: %v", err)
+	}
+}
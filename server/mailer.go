@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// Sends emails through SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Writes emails to files in Dir. Used for development and tests
+type OutboxMailer struct {
+	Dir  string
+	From string
+}
+
+var mailer Mailer = newMailer()
+
+func newMailer() Mailer {
+	if config.SmtpHost == "" {
+		return &OutboxMailer{Dir: config.OutboxDir, From: config.MailFrom}
+	}
+	return &SMTPMailer{
+		Host:     config.SmtpHost,
+		Port:     config.SmtpPort,
+		Username: config.SmtpUsername,
+		Password: config.SmtpPassword,
+		From:     config.MailFrom,
+	}
+}
+
+func (m *SMTPMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+	return smtp.SendMail(m.Host+":"+m.Port, auth, m.From, []string{to}, formatMail(m.From, to, subject, body))
+}
+
+func (m *OutboxMailer) Send(to string, subject string, body string) error {
+	err := os.MkdirAll(m.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%v-%v.eml", getCurrentMilli(), generateSessionId())
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), formatMail(m.From, to, subject, body), 0600)
+}
+
+func formatMail(from string, to string, subject string, body string) []byte {
+	// Header values must not contain line breaks
+	clean := strings.NewReplacer("\r", "", "\n", "")
+	message := "From: " + clean.Replace(from) + "\r\n" +
+		"To: " + clean.Replace(to) + "\r\n" +
+		"Subject: " + clean.Replace(subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+	return []byte(message)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -21,12 +22,34 @@ func main() {
 	db = dbLocal
 	defer db.Close()
 	createTables()
+	_, err = getSecretKey()
+	if err != nil {
+		log.Fatal(err)
+	}
 	// err = printUsers()
 	if err != nil {
 		fmt.Println(err)
 	}
 
-	http.Handle("/", http.FileServer(http.Dir("../")))
+	// Administrative commands, see cli.go. Without arguments server is started
+	if len(os.Args) > 1 && os.Args[1] != "serve" {
+		code := runCommand(os.Args[1:])
+		db.Close()
+		os.Exit(code)
+	}
+	serve()
+}
+
+func serve() {
+	// Emails in outbox hold sign in tokens
+	if isServedDir(config.OutboxDir) {
+		log.Fatal("OUTBOX_DIR must not be inside served client directory")
+	}
+	// Uploads are served only through attachmentHandler, which checks access
+	if config.Storage != "s3" && isServedDir(config.StorageDir) {
+		log.Fatal("STORAGE_DIR must not be inside served client directory")
+	}
+	http.Handle("/", clientHandler())
 	http.HandleFunc("/home", homeHandler)
 	http.HandleFunc("/signup", signupHandler)
 	http.HandleFunc("/signin", signinHandler)
@@ -35,7 +58,77 @@ func main() {
 	http.HandleFunc("/message", messageHandler)
 	http.HandleFunc("/messages", messagesHandler)
 	http.HandleFunc("/comments", commentsHandler)
+	http.HandleFunc("/profile", profileHandler)
+	http.HandleFunc("/changepassword", changePasswordHandler)
+	http.HandleFunc("/forgotpassword", forgotPasswordHandler)
+	http.HandleFunc("/resetpassword", resetPasswordHandler)
+	http.HandleFunc("/verifyemail", verifyEmailHandler)
+	http.HandleFunc("/resendverification", resendVerificationHandler)
+	http.HandleFunc("/signintotp", signinTOTPHandler)
+	http.HandleFunc("/totpsetup", totpSetupHandler)
+	http.HandleFunc("/totpenable", totpEnableHandler)
+	http.HandleFunc("/totpdisable", totpDisableHandler)
+	http.HandleFunc("/totprecoverycodes", totpRecoveryCodesHandler)
+	http.HandleFunc("/oidclogin", oidcLoginHandler)
+	http.HandleFunc("/oidccallback", oidcCallbackHandler)
+	http.HandleFunc("/deletepost", requirePermission(PERMISSION_REMOVE_CONTENT, deletePostHandler))
+	http.HandleFunc("/deletecomment", requirePermission(PERMISSION_REMOVE_CONTENT, deleteCommentHandler))
+	http.HandleFunc("/pinpost", requirePermission(PERMISSION_MANAGE_POSTS, pinPostHandler))
+	http.HandleFunc("/lockpost", requirePermission(PERMISSION_MANAGE_POSTS, lockPostHandler))
+	http.HandleFunc("/addcategory", requirePermission(PERMISSION_MANAGE_CATEGORIES, addCategoryHandler))
+	http.HandleFunc("/renamecategory", requirePermission(PERMISSION_MANAGE_CATEGORIES, renameCategoryHandler))
+	http.HandleFunc("/removecategory", requirePermission(PERMISSION_MANAGE_CATEGORIES, removeCategoryHandler))
+	http.HandleFunc("/users", requirePermission(PERMISSION_MANAGE_USERS, usersHandler))
+	http.HandleFunc("/setrole", requirePermission(PERMISSION_MANAGE_USERS, setRoleHandler))
+	http.HandleFunc("/report", reportHandler)
+	http.HandleFunc("/reports", requirePermission(PERMISSION_MODERATE_REPORTS, reportsHandler))
+	http.HandleFunc("/moderate", requirePermission(PERMISSION_MODERATE_REPORTS, moderateHandler))
+	http.HandleFunc("/sanction", requirePermission(PERMISSION_SANCTION_USERS, sanctionHandler))
+	http.HandleFunc("/revokesanction", requirePermission(PERMISSION_SANCTION_USERS, revokeSanctionHandler))
+	http.HandleFunc("/sanctions", requirePermission(PERMISSION_SANCTION_USERS, sanctionsHandler))
+	http.HandleFunc("/banip", requirePermission(PERMISSION_MANAGE_USERS, banIpHandler))
+	http.HandleFunc("/unbanip", requirePermission(PERMISSION_MANAGE_USERS, unbanIpHandler))
+	http.HandleFunc("/ipbans", requirePermission(PERMISSION_MANAGE_USERS, ipBansHandler))
+	http.HandleFunc("/block", requireUser(blockHandler))
+	http.HandleFunc("/unblock", requireUser(unblockHandler))
+	http.HandleFunc("/blocks", requireUser(blocksHandler))
+	http.HandleFunc("/messagerequests", requireUser(messageRequestsHandler))
+	http.HandleFunc("/messagerequest", requireUser(messageRequestHandler))
+	http.HandleFunc("/notifications", requireUser(notificationsHandler))
+	http.HandleFunc("/unreadnotifications", requireUser(unreadNotificationsHandler))
+	http.HandleFunc("/readnotifications", requireUser(readNotificationsHandler))
+	http.HandleFunc("/attachment", requireUser(attachmentHandler))
+	http.HandleFunc("/storage", requireUser(storageHandler))
+	http.HandleFunc("/avatar", avatarHandler)
+	http.HandleFunc("/uploadavatar", uploadAvatarHandler)
+	http.HandleFunc("/removeavatar", requireUser(removeAvatarHandler))
+	http.HandleFunc("/drafts", requireUser(draftsHandler))
+	http.HandleFunc("/savedraft", requireUser(saveDraftHandler))
+	http.HandleFunc("/deletedraft", requireUser(deleteDraftHandler))
+	http.HandleFunc("/scheduled", requireUser(scheduledHandler))
+	http.HandleFunc("/reschedule", requireUser(rescheduleHandler))
+	http.HandleFunc("/cancelscheduled", requireUser(cancelScheduledHandler))
+	http.HandleFunc("/vote", requireUser(voteHandler))
+	http.HandleFunc("/unvote", requireUser(unvoteHandler))
+	http.HandleFunc("/bookmark", requireUser(bookmarkHandler))
+	http.HandleFunc("/unbookmark", requireUser(unbookmarkHandler))
+	http.HandleFunc("/bookmarks", requireUser(bookmarksHandler))
+	http.HandleFunc("/bookmarkcollections", requireUser(bookmarkCollectionsHandler))
+	http.HandleFunc("/addbookmarkcollection", requireUser(addBookmarkCollectionHandler))
+	http.HandleFunc("/renamebookmarkcollection", requireUser(renameBookmarkCollectionHandler))
+	http.HandleFunc("/removebookmarkcollection", requireUser(removeBookmarkCollectionHandler))
+	http.HandleFunc("/follow", requireUser(followHandler))
+	http.HandleFunc("/unfollow", requireUser(unfollowHandler))
+	http.HandleFunc("/followcategory", requireUser(followCategoryHandler))
+	http.HandleFunc("/unfollowcategory", requireUser(unfollowCategoryHandler))
+	http.HandleFunc("/following", requireUser(followingHandler))
+	http.HandleFunc("/followers", requireUser(followersHandler))
+	http.HandleFunc("/subscribe", requireUser(subscribeHandler))
+	http.HandleFunc("/unsubscribe", requireUser(unsubscribeHandler))
+	http.HandleFunc("/subscriptions", requireUser(subscriptionsHandler))
 	http.HandleFunc("/ws/", websocketHandler)
+	go expireDrafts()
+	go runScheduler()
 	fmt.Println("Server running at port 8080")
 	http.ListenAndServe(":8080", nil)
 
@@ -78,7 +171,7 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if user != nil {
-			posts, err := getPosts(user)
+			posts, err := getPosts(user, r.URL.Query().Get("category"), r.URL.Query().Get("feed") == FOLLOWING_FEED)
 			if err != nil {
 				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
 				json.NewEncoder(w).Encode(resp)
@@ -101,6 +194,12 @@ func signinHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := Response{Payload: nil, Error: nil}
 
+	resp.Error = checkIpAllowed(r)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
 	user_name := r.FormValue("user_name")
 	password := r.FormValue("password")
 
@@ -113,30 +212,59 @@ func signinHandler(w http.ResponseWriter, r *http.Request) {
 			resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
 		} else {
 
-			user.SessionId = generateSessionId()
-			err := updateSessionId(user)
+			totp, err := getTOTP(user.Id)
 			if err != nil {
 				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: unable access database: %v", err)}
 				json.NewEncoder(w).Encode(resp)
 				return
 			}
 
-			posts, err := getPosts(user)
-			if err != nil {
-				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: unable access database: %v", err)}
-				json.NewEncoder(w).Encode(resp)
-				return
+			if totp.Enabled {
+				// Session is issued by /signintotp once code is checked
+				challenge, err := newTOTPChallenge(user.Id)
+				if err != nil {
+					resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+					json.NewEncoder(w).Encode(resp)
+					return
+				}
+				resp.Error = &Error{Type: TWO_FACTOR_REQUIRED, Message: "Error: enter code from authenticator app"}
+				resp.Payload = TwoFactorChallenge{Challenge: challenge}
+			} else {
+				data, e := startSession(user)
+				resp.Error = e
+				if e == nil {
+					resp.Payload = data
+				}
 			}
-
-			removeUserInfo(user)
-			data := Data{Posts: posts, User: user}
-			resp.Payload = data
 		}
 	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Issue new session id for signed in user and load home page data
+func startSession(user *User) (*Data, *Error) {
+	e := checkNotBanned(user)
+	if e != nil {
+		return nil, e
+	}
+
+	user.SessionId = generateSessionId()
+	err := updateSessionId(user)
+	if err != nil {
+		return nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: unable access database: %v", err)}
+	}
+
+	posts, err := getPosts(user, "", false)
+	if err != nil {
+		return nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: unable access database: %v", err)}
+	}
+
+	removeUserInfo(user)
+	data := Data{Posts: posts, User: user}
+	return &data, nil
+}
+
 func signupHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := Response{Payload: nil, Error: nil}
@@ -152,7 +280,10 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 	data.User.Password2 = r.FormValue("password2")
 
 	age_str := strings.TrimSpace(r.FormValue("age"))
-	resp.Error = validateInput(data.User, age_str)
+	resp.Error = checkIpAllowed(r)
+	if resp.Error == nil {
+		resp.Error = validateInput(data.User, age_str)
+	}
 
 	if resp.Error == nil {
 		// Try to insert User
@@ -161,24 +292,25 @@ func signupHandler(w http.ResponseWriter, r *http.Request) {
 		data.User.Password2 = ""
 		id, err := saveUser(data.User)
 		if err != nil {
-			if strings.HasPrefix(err.Error(), "UNIQUE constraint failed: users.nick_name") {
-				resp.Error = &Error{Type: INVALID_NICK_NAME, Message: "Error: nick name is already in use"}
-				resp.Payload = nil
-			} else if strings.HasPrefix(err.Error(), "UNIQUE constraint failed: users.email") {
-				resp.Error = &Error{Type: INVALID_EMAIL, Message: "Error: email is already in use"}
-				resp.Payload = nil
-			} else {
-				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
-				resp.Payload = nil
-			}
+			resp.Error = userSaveError(err)
+			resp.Payload = nil
 		} else {
 			data.User.Id = int(id)
+
+			// New account stays unverified until link from email is opened
+			_, err = updateVerificationSent(data.User.Id, 0)
+			if err == nil {
+				err = sendVerificationEmail(data.User)
+			}
+			if err != nil {
+				errorHandler(err)
+			}
 		}
 
 	}
 	if resp.Error == nil {
 
-		posts, err := getPosts(data.User)
+		posts, err := getPosts(data.User, "", false)
 		if err != nil {
 			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: unable access database: %v", err)}
 			resp.Payload = nil
@@ -271,12 +403,35 @@ func newpostHandler(w http.ResponseWriter, r *http.Request) {
 		resp.Payload = npop
 
 	} else if r.Method == "POST" {
+		uploads, e := parseAttachments(w, r)
+		if e != nil {
+			resp.Error = e
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		session_id := r.FormValue("session_id")
+		title := strings.TrimSpace(r.FormValue("title"))
 		content := strings.TrimSpace(r.FormValue("content"))
 		categories := r.FormValue("categories")
 
+		// Optional time to publish post at
+		publishAt, e := parsePublishAt(r)
+		if e != nil {
+			resp.Error = e
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		poll, e := parsePoll(r, publishAt)
+		if e != nil {
+			resp.Error = e
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		//0. Validate content
-		if len(content) == 0 {
+		if len(content) == 0 && len(uploads) == 0 && poll == nil {
 			resp.Error = &Error{Type: INVALID_INPUT, Message: "Empty post is not allowed"}
 			json.NewEncoder(w).Encode(resp)
 			return
@@ -288,6 +443,12 @@ func newpostHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if len(title) > 200 {
+			resp.Error = &Error{Type: INVALID_INPUT, Message: "Title is too large"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		// 1.Verify session_id
 		user, err := getUserBySessionId(session_id)
 		if user == nil {
@@ -301,6 +462,18 @@ func newpostHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		resp.Error = checkCanWrite(user, config.UnverifiedCanPost)
+		if resp.Error != nil {
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		resp.Error = checkStorageQuota(user, uploads)
+		if resp.Error != nil {
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		removeUserInfo(user)
 
 		// 2. Insert Post
@@ -313,8 +486,10 @@ func newpostHandler(w http.ResponseWriter, r *http.Request) {
 
 		post := Post{
 			UserId:     user.Id,
+			Title:      title,
 			Content:    content,
 			Categories: arr,
+			PublishAt:  publishAt,
 		}
 		err = insertPost(user, &post)
 
@@ -323,6 +498,41 @@ func newpostHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(resp)
 			return
 		}
+
+		if poll != nil {
+			err = insertPoll(post.Id, poll)
+			if err != nil {
+				deletePost(post.Id)
+				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			poll.Voted = []int{}
+			post.Poll = poll
+		}
+
+		post.Attachments, err = saveAttachments(user, CONTENT_POST, post.Id, uploads)
+		if err != nil {
+			deletePost(post.Id)
+			resp.Error = &Error{Type: ERROR_STORING_FILE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		removeDraft(user.Id, DRAFT_POST)
+
+		post.NickName = user.NickName
+		post.AvatarUrl = user.AvatarUrl
+		if post.PublishAt > 0 {
+			// Scheduler publishes it later, only author gets it now
+			post.ContentHtml = renderMarkdown(post.Content)
+			post.Mentions = []Mention{}
+			post.LinkPreviews = []LinkPreview{}
+			resp.Payload = post
+			wakeScheduler()
+		} else {
+			publishPost(user, &post)
+		}
 	} else {
 		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
 	}
@@ -330,6 +540,30 @@ func newpostHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Save mentions of new post, notify mentioned users and send post to feed
+func publishPost(user *User, post *Post) {
+	var err error
+	post.ContentHtml = renderMarkdown(post.Content)
+	post.Mentions, err = saveMentions(CONTENT_POST, post.Id, post.Content)
+	if err == nil {
+		err = notifyMentions(user, post.Mentions, post.Id, 0, post.Content, nil)
+	}
+	if err == nil {
+		err = insertSubscription(user.Id, post.Id)
+	}
+	if err != nil {
+		errorHandler(err)
+	}
+
+	post.LinkPreviews, err = getLinkPreviews(post.Content)
+	if err != nil {
+		errorHandler(err)
+	}
+
+	publishFeedEvent(postCreatedEvent(post))
+	publishContentLinkPreviews(post, 0, post.Content)
+}
+
 func messageHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := Response{Payload: nil, Error: nil}
@@ -340,12 +574,27 @@ func messageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	uploads, e := parseAttachments(w, r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
 	session_id := r.FormValue("session_id")
 	to_id := r.FormValue("to_id")
 	message := strings.TrimSpace(r.FormValue("message"))
 
+	// Optional time to send message at
+	publishAt, e := parsePublishAt(r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
 	//Verify input
-	if len(message) == 0 {
+	if len(message) == 0 && len(uploads) == 0 {
 		resp.Error = &Error{Type: INVALID_INPUT, Message: "Empty message is not allowed"}
 		json.NewEncoder(w).Encode(resp)
 		return
@@ -368,6 +617,12 @@ func messageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	resp.Error = checkCanWrite(user, config.UnverifiedCanMessage)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
 	to_id_int, err := strconv.Atoi(to_id)
 	if err != nil {
 		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
@@ -375,6 +630,19 @@ func messageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	pending, e := checkCanMessage(user, to_id_int)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Error = checkStorageQuota(user, uploads)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
 	removeUserInfo(user)
 
 	resp.Payload = user
@@ -385,32 +653,97 @@ func messageHandler(w http.ResponseWriter, r *http.Request) {
 		ToId:         to_id_int,
 		Content:      message,
 		Date:         getCurrentMilli(),
+		Pending:      pending,
+		PublishAt:    publishAt,
+	}
+	// Whether scheduled message is a request is decided when it is sent
+	if m.PublishAt > 0 {
+		m.Pending = false
 	}
 
-	err = insertMessage(m)
+	err = insertMessage(&m)
 	if err != nil {
 		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
 		json.NewEncoder(w).Encode(resp)
 		return
 	}
 
-	mw := MessageWrapper{m}
+	m.Attachments, err = saveAttachments(user, CONTENT_MESSAGE, m.Id, uploads)
+	if err != nil {
+		deleteMessage(m.Id)
+		resp.Error = &Error{Type: ERROR_STORING_FILE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
 
-	b, err := json.Marshal(mw)
+	removeDraft(user.Id, messageDraftTarget(m.ToId))
 
-	if err != nil {
-		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+	if m.PublishAt > 0 {
+		// Scheduler sends it later
+		m.ContentHtml = renderMarkdown(m.Content)
+		m.Mentions = []Mention{}
+		m.LinkPreviews = []LinkPreview{}
+		resp.Payload = m
+		wakeScheduler()
 		json.NewEncoder(w).Encode(resp)
 		return
 	}
 
-	//Notify both sender and receiver
-	notifyClient(m.FromId, b)
-	notifyClient(m.ToId, b)
+	err = sendMessage(&m)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
+// Save mentions of new message, notify recipient and push message to both users
+func sendMessage(m *Message) error {
+	var err error
+	m.ContentHtml = renderMarkdown(m.Content)
+	m.Mentions, err = saveMentions(CONTENT_MESSAGE, m.Id, m.Content)
+	if err != nil {
+		errorHandler(err)
+	}
+	m.LinkPreviews, err = getLinkPreviews(m.Content)
+	if err != nil {
+		errorHandler(err)
+	}
+
+	//Replying to message request accepts it
+	if !m.Pending {
+		err = acceptMessageRequest(m.FromId, m.ToId)
+		if err != nil {
+			errorHandler(err)
+		}
+	}
+
+	err = notifyMessage(m)
+	if err != nil {
+		errorHandler(err)
+	}
+
+	mw := MessageWrapper{*m}
+
+	b, err := json.Marshal(mw)
+
+	if err != nil {
+		return err
+	}
+
+	//Notify both sender and receiver. Receiver of message request gets only a notice
+	notifyClient(m.FromId, b)
+	if m.Pending {
+		b, err = json.Marshal(MessageRequestWrapper{MessageRequest{FromId: m.FromId, FromNickName: m.FromNickName, Count: 1, Date: m.Date, Content: m.Content}})
+		if err != nil {
+			return err
+		}
+	}
+	notifyClient(m.ToId, b)
+	publishMessageLinkPreviews(m)
+	return nil
+}
+
 func commentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	resp := Response{Payload: nil, Error: nil}
@@ -462,6 +795,25 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(resp)
 			return
 		}
+		// Hidden and scheduled posts are not found, nor are their comments
+		if post.Id == 0 {
+			resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: post not found"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		err = setPollVoted(post.Poll, post.Id, user.Id)
+		if err == nil {
+			post.Bookmarked, err = isBookmarked(user.Id, CONTENT_POST, post.Id)
+		}
+		if err == nil {
+			post.Subscribed, err = isSubscribedToPost(user.Id, post.Id)
+		}
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
 
 		//4. Get Comments
 		comments, err := getComments(postId)
@@ -470,6 +822,15 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 			json.NewEncoder(w).Encode(resp)
 			return
 		}
+		bookmarked, err := getBookmarkedIds(user.Id, CONTENT_COMMENT)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		for _, comment := range comments {
+			comment.Bookmarked = bookmarked[comment.Id]
+		}
 
 		cpo := CommentsPageObject{}
 		cpo.User = user
@@ -478,12 +839,19 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 		resp.Payload = cpo
 
 	} else if r.Method == "POST" {
+		uploads, e := parseAttachments(w, r)
+		if e != nil {
+			resp.Error = e
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		session_id := r.FormValue("session_id")
 		post_id := r.FormValue("post_id")
 		comment := strings.TrimSpace(r.FormValue("comment"))
 
 		//Verify comment
-		if len(comment) == 0 {
+		if len(comment) == 0 && len(uploads) == 0 {
 			resp.Error = &Error{Type: INVALID_INPUT, Message: "Empty comment is not allowed"}
 			json.NewEncoder(w).Encode(resp)
 			return
@@ -507,6 +875,18 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		resp.Error = checkCanWrite(user, config.UnverifiedCanComment)
+		if resp.Error != nil {
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		resp.Error = checkStorageQuota(user, uploads)
+		if resp.Error != nil {
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
 		postId, err := strconv.Atoi(post_id)
 
 		if err != nil {
@@ -515,7 +895,48 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		err = saveComment(user.Id, postId, comment)
+		post, err := getPost(postId)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if post.Id == 0 {
+			resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: post not found"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		// Moderators can still explain why post was locked
+		if post.Locked && !hasPermission(user, PERMISSION_MANAGE_POSTS) {
+			resp.Error = &Error{Type: POST_LOCKED, Message: "Error: post is locked, new comments are not allowed"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		c := Comment{UserId: user.Id, UserNickName: user.NickName, UserAvatarUrl: user.AvatarUrl, PostId: postId, Content: comment}
+
+		//Optional comment this one replies to
+		if r.FormValue("parent_id") != "" {
+			c.ParentId, err = strconv.Atoi(r.FormValue("parent_id"))
+			if err != nil {
+				resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: unable to parse data %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			parent, err := getComment(c.ParentId)
+			if err != nil {
+				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			if parent == nil || parent.PostId != postId {
+				resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: comment not found"}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+		}
+
+		err = saveComment(&c)
 
 		if err != nil {
 			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
@@ -523,6 +944,46 @@ func commentsHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		c.Attachments, err = saveAttachments(user, CONTENT_COMMENT, c.Id, uploads)
+		if err != nil {
+			deleteComment(c.Id)
+			resp.Error = &Error{Type: ERROR_STORING_FILE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		c.ContentHtml = renderMarkdown(c.Content)
+		c.Mentions, err = saveMentions(CONTENT_COMMENT, c.Id, c.Content)
+		if err != nil {
+			errorHandler(err)
+		}
+
+		// Nobody is notified before comment is stored with its attachments
+		notified, err := notifyComment(user, post, &c)
+		if err != nil {
+			errorHandler(err)
+		}
+		err = notifyMentions(user, c.Mentions, post.Id, c.Id, c.Content, notified)
+		if err == nil {
+			err = notifySubscribers(user, post, &c, notified)
+		}
+		// Commenters follow the rest of the thread
+		if err == nil {
+			err = insertSubscription(user.Id, post.Id)
+		}
+		if err != nil {
+			errorHandler(err)
+		}
+
+		c.LinkPreviews, err = getLinkPreviews(c.Content)
+		if err != nil {
+			errorHandler(err)
+		}
+
+		removeDraft(user.Id, commentDraftTarget(post.Id))
+		publishFeedEvent(commentCreatedEvent(post, &c))
+		publishContentLinkPreviews(post, c.Id, c.Content)
+
 	} else {
 		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
 	}
@@ -592,6 +1053,27 @@ func Cors(w http.ResponseWriter, r *http.Request) {
 }
 
 func validateInput(user *User, age_str string) *Error {
+	e := validateUserInfo(user, age_str)
+	if e != nil {
+		return e
+	}
+	return validatePassword(user)
+}
+
+func validatePassword(user *User) *Error {
+	// Validate password
+	if len(user.Password) < 6 || len(user.Password) > 50 {
+		return &Error{Type: INVALID_PASSWORD, Message: "Error: password should be between 6 and 50 characters long"}
+	}
+	// Validate passwords
+	if user.Password != user.Password2 {
+		return &Error{Type: INVALID_PASSWORD_2, Message: "Error: passwords don't match"}
+	}
+	return nil
+}
+
+// Validate everything entered on sign up except passwords
+func validateUserInfo(user *User, age_str string) *Error {
 	if len(user.FirstName) < 2 || len(user.FirstName) > 50 {
 		return &Error{Type: INVALID_FIRST_NAME, Message: "Error: first name should be between 2 and 50 characters long"}
 	}
@@ -625,21 +1107,27 @@ func validateInput(user *User, age_str string) *Error {
 		return &Error{Type: INVALID_EMAIL, Message: "Error: invalid email"}
 	}
 
-	// Validate password
-	if len(user.Password) < 6 || len(user.Password) > 50 {
-		return &Error{Type: INVALID_PASSWORD, Message: "Error: password should be between 6 and 50 characters long"}
+	return nil
+}
+
+// Convert error returned by saveUser/updateUser into response error
+func userSaveError(err error) *Error {
+	if strings.HasPrefix(err.Error(), "UNIQUE constraint failed: users.nick_name") {
+		return &Error{Type: INVALID_NICK_NAME, Message: "Error: nick name is already in use"}
 	}
-	// Validate passwords
-	if user.Password != user.Password2 {
-		return &Error{Type: INVALID_PASSWORD_2, Message: "Error: passwords don't match"}
+	if strings.HasPrefix(err.Error(), "UNIQUE constraint failed: users.email") {
+		return &Error{Type: INVALID_EMAIL, Message: "Error: email is already in use"}
 	}
-
-	return nil
+	return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
 }
 
 func createTables() {
 
-	err := crerateUsersTable()
+	err := crerateSettingsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateUsersTable()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -655,12 +1143,115 @@ func createTables() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	_ = insertCategories([]string{"gereen apple", "cucumber", "kivi", "green grapes", "avocado", "broccoli", "spinach"})
+	// Default categories on first start only, so categories removed by admin stay removed
+	categories, err := getCategories()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(categories) == 0 {
+		_ = insertCategories([]string{"gereen apple", "cucumber", "kivi", "green grapes", "avocado", "broccoli", "spinach"})
+	}
 
 	err = crerateCommentsTable()
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = creratePrivacyTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = creratePasswordResetsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateRecoveryCodesTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateTOTPChallengesTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateIdentitiesTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateReportsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateWarningsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateSanctionsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateIpBansTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateBlocksTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateNotificationsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateMentionsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateAttachmentsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateLinkPreviewsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateDraftsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = creratePollsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = creratePollOptionsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = creratePollVotesTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateBookmarkCollectionsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateBookmarksTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateFollowsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateCategoryFollowsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateFollowNotificationsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = crerateSubscriptionsTable()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func removeUserInfo(user *User) {
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// Point db to fresh database with all tables for duration of test
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	testDb, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "forum.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db
+	db = testDb
+	createTables()
+	t.Cleanup(func() {
+		testDb.Close()
+		db = previous
+	})
+}
+
+// Save member with given nick name and return it with its id
+func createTestUser(t *testing.T, nickName string) *User {
+	t.Helper()
+	user := &User{
+		FirstName: "Test",
+		LastName:  "User",
+		Age:       30,
+		Gender:    "Female",
+		NickName:  nickName,
+		Email:     fmt.Sprintf("%v@example.com", nickName),
+		Password:  encrypt("secret1"),
+		Verified:  true,
+	}
+	id, err := saveUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.Id = int(id)
+	return user
+}
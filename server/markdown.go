@@ -0,0 +1,429 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Markdown renderer for post, comment and message content. Supported subset of CommonMark:
+//
+//	paragraphs, ATX headings (# to ######), fenced code blocks (``` and ~~~),
+//	block quotes, bullet and ordered lists, thematic breaks,
+//	code spans, *emphasis*, **strong emphasis**, [links](url "title"), <autolinks>
+//	and backslash escapes
+//
+// Line breaks inside paragraph are kept. Raw HTML in source is never passed through,
+// all text is escaped, so output contains only these tags:
+//
+//	p br h1-h6 pre code blockquote ul ol li hr em strong a
+//
+// Links are allowed only to http, https and mailto URLs and relative paths
+// and always get rel="nofollow noopener noreferrer".
+
+var (
+	mdFenceRegexp   = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)")
+	mdHeadingRegexp = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdRuleRegexp    = regexp.MustCompile(`^ {0,3}(?:(?:\*[ \t]*){3,}|(?:-[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdQuoteRegexp   = regexp.MustCompile(`^ {0,3}> ?`)
+	mdBulletRegexp  = regexp.MustCompile(`^ {0,3}([-*+])[ \t]+(.*)$`)
+	mdOrderedRegexp = regexp.MustCompile(`^ {0,3}(\d{1,9})([.)])[ \t]+(.*)$`)
+	mdLanguage      = regexp.MustCompile(`^[A-Za-z0-9_+#.\-]+$`)
+	mdAutolink      = regexp.MustCompile(`^<((?:https?://|mailto:)[^<>\s]+)>`)
+)
+
+func renderMarkdown(source string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	source = strings.ReplaceAll(source, "\r", "\n")
+	return renderBlocks(strings.Split(source, "\n"))
+}
+
+func renderBlocks(lines []string) string {
+	out := []string{}
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			i++
+			continue
+		}
+
+		if m := mdFenceRegexp.FindStringSubmatch(line); m != nil {
+			fence := m[1]
+			code := []string{}
+			i++
+			for i < len(lines) && !isClosingFence(lines[i], fence) {
+				code = append(code, lines[i])
+				i++
+			}
+			i++
+			class := ""
+			if mdLanguage.MatchString(m[2]) {
+				class = ` class="language-` + html.EscapeString(m[2]) + `"`
+			}
+			body := html.EscapeString(strings.Join(code, "\n"))
+			if len(code) > 0 {
+				body += "\n"
+			}
+			out = append(out, "<pre><code"+class+">"+body+"</code></pre>")
+			continue
+		}
+
+		if m := mdHeadingRegexp.FindStringSubmatch(line); m != nil {
+			tag := "h" + strconv.Itoa(len(m[1]))
+			out = append(out, "<"+tag+">"+renderInline(strings.TrimSpace(m[2]))+"</"+tag+">")
+			i++
+			continue
+		}
+
+		if mdRuleRegexp.MatchString(line) {
+			out = append(out, "<hr>")
+			i++
+			continue
+		}
+
+		if mdQuoteRegexp.MatchString(line) {
+			quote := []string{}
+			for i < len(lines) && mdQuoteRegexp.MatchString(lines[i]) {
+				quote = append(quote, mdQuoteRegexp.ReplaceAllString(lines[i], ""))
+				i++
+			}
+			out = append(out, "<blockquote>\n"+renderBlocks(quote)+"\n</blockquote>")
+			continue
+		}
+
+		if isListItem(line) {
+			var list string
+			list, i = renderList(lines, i)
+			out = append(out, list)
+			continue
+		}
+
+		paragraph := []string{}
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" && (len(paragraph) == 0 || !startsBlock(lines[i])) {
+			paragraph = append(paragraph, strings.TrimSpace(lines[i]))
+			i++
+		}
+		out = append(out, "<p>"+renderInline(strings.Join(paragraph, "\n"))+"</p>")
+	}
+	return strings.Join(out, "\n")
+}
+
+func isClosingFence(line string, fence string) bool {
+	trimmed := strings.TrimSpace(line)
+	return strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == ""
+}
+
+func startsBlock(line string) bool {
+	return mdFenceRegexp.MatchString(line) || mdHeadingRegexp.MatchString(line) || mdRuleRegexp.MatchString(line) ||
+		mdQuoteRegexp.MatchString(line) || isListItem(line)
+}
+
+func isListItem(line string) bool {
+	return mdBulletRegexp.MatchString(line) || mdOrderedRegexp.MatchString(line)
+}
+
+// Render list starting at lines[start]. Indented lines continue current item,
+// blank line ends list unless it is followed by next item of the same list
+func renderList(lines []string, start int) (string, int) {
+	ordered := !mdBulletRegexp.MatchString(lines[start])
+	marker := listMarker(lines[start])
+	open := "<ul>"
+	closing := "</ul>"
+	if ordered {
+		open = "<ol>"
+		closing = "</ol>"
+		m := mdOrderedRegexp.FindStringSubmatch(lines[start])
+		if n, err := strconv.Atoi(m[1]); err == nil && n != 1 {
+			open = `<ol start="` + strconv.Itoa(n) + `">`
+		}
+	}
+
+	items := [][]string{}
+	i := start
+	for i < len(lines) {
+		line := lines[i]
+		if isListItem(line) && listMarker(line) == marker && !mdRuleRegexp.MatchString(line) {
+			items = append(items, []string{listItemText(line)})
+			i++
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			if i+1 < len(lines) && isListItem(lines[i+1]) && listMarker(lines[i+1]) == marker {
+				i++
+				continue
+			}
+			break
+		}
+		if strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t") {
+			items[len(items)-1] = append(items[len(items)-1], strings.TrimSpace(line))
+			i++
+			continue
+		}
+		break
+	}
+
+	out := []string{open}
+	for _, item := range items {
+		out = append(out, "<li>"+renderInline(strings.Join(item, "\n"))+"</li>")
+	}
+	out = append(out, closing)
+	return strings.Join(out, "\n"), i
+}
+
+// Bullet character, or delimiter of ordered list
+func listMarker(line string) string {
+	if m := mdBulletRegexp.FindStringSubmatch(line); m != nil {
+		return m[1]
+	}
+	if m := mdOrderedRegexp.FindStringSubmatch(line); m != nil {
+		return "1" + m[2]
+	}
+	return ""
+}
+
+func listItemText(line string) string {
+	if m := mdBulletRegexp.FindStringSubmatch(line); m != nil {
+		return m[2]
+	}
+	m := mdOrderedRegexp.FindStringSubmatch(line)
+	return m[3]
+}
+
+func renderInline(text string) string {
+	var b strings.Builder
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", text[i+1]) >= 0:
+			b.WriteString(html.EscapeString(text[i+1 : i+2]))
+			i += 2
+			continue
+
+		case c == '\n':
+			b.WriteString("<br>\n")
+			i++
+			continue
+
+		case c == '`':
+			n := runLength(text, i, '`')
+			end := findRun(text, i+n, '`', n)
+			if end >= 0 {
+				code := text[i+n : end]
+				if len(code) > 2 && code[0] == ' ' && code[len(code)-1] == ' ' {
+					code = code[1 : len(code)-1]
+				}
+				b.WriteString("<code>" + html.EscapeString(code) + "</code>")
+				i = end + n
+			} else {
+				b.WriteString(text[i : i+n])
+				i += n
+			}
+			continue
+
+		case c == '*' || c == '_':
+			n := runLength(text, i, c)
+			if n > 2 {
+				n = 2
+			}
+			if rendered, next, ok := renderEmphasis(text, i, c, n); ok {
+				b.WriteString(rendered)
+				i = next
+				continue
+			}
+			if n == 2 {
+				if rendered, next, ok := renderEmphasis(text, i, c, 1); ok {
+					b.WriteString(rendered)
+					i = next
+					continue
+				}
+			}
+			b.WriteString(text[i : i+n])
+			i += n
+			continue
+
+		case c == '[' || (c == '!' && i+1 < len(text) && text[i+1] == '['):
+			// Images are not allowed, they are shown as links
+			open := i
+			if c == '!' {
+				open++
+			}
+			if rendered, next, ok := renderLink(text, open); ok {
+				b.WriteString(rendered)
+				i = next
+				continue
+			}
+
+		case c == '<':
+			if m := mdAutolink.FindStringSubmatch(text[i:]); m != nil {
+				b.WriteString(linkTag(m[1], "", html.EscapeString(m[1])))
+				i += len(m[0])
+				continue
+			}
+		}
+		b.WriteString(html.EscapeString(text[i : i+1]))
+		i++
+	}
+	return b.String()
+}
+
+func runLength(text string, i int, c byte) int {
+	n := 0
+	for i+n < len(text) && text[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// Position of next run of exactly n characters c, -1 if there is none
+func findRun(text string, from int, c byte, n int) int {
+	for i := from; i < len(text); {
+		if text[i] != c {
+			i++
+			continue
+		}
+		length := runLength(text, i, c)
+		if length == n {
+			return i
+		}
+		i += length
+	}
+	return -1
+}
+
+// *em* and **strong**. Delimiters must touch the text they surround,
+// underscores inside words do not start emphasis
+func renderEmphasis(text string, i int, c byte, n int) (string, int, bool) {
+	start := i + n
+	if start >= len(text) || text[start] == ' ' || text[start] == '\n' {
+		return "", 0, false
+	}
+	if c == '_' && i > 0 && isWordChar(text[i-1]) {
+		return "", 0, false
+	}
+	delimiter := strings.Repeat(string(c), n)
+	for j := start + 1; j+n <= len(text); j++ {
+		if text[j-1] == '\\' {
+			continue
+		}
+		if text[j:j+n] != delimiter || text[j-1] == ' ' || text[j-1] == '\n' {
+			continue
+		}
+		// Closing run must not be longer, e.g. ** does not close *
+		if text[j-1] == c {
+			continue
+		}
+		if j+n < len(text) && text[j+n] == c {
+			j += runLength(text, j, c) - 1
+			continue
+		}
+		if c == '_' && j+n < len(text) && isWordChar(text[j+n]) {
+			continue
+		}
+		tag := "em"
+		if n == 2 {
+			tag = "strong"
+		}
+		return "<" + tag + ">" + renderInline(text[start:j]) + "</" + tag + ">", j + n, true
+	}
+	return "", 0, false
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
+// [text](url "title") starting at text[i] == '['
+func renderLink(text string, i int) (string, int, bool) {
+	depth := 0
+	closeBracket := -1
+	for j := i; j < len(text); j++ {
+		if text[j] == '\\' {
+			j++
+			continue
+		}
+		if text[j] == '[' {
+			depth++
+		} else if text[j] == ']' {
+			depth--
+			if depth == 0 {
+				closeBracket = j
+				break
+			}
+		}
+	}
+	if closeBracket < 0 || closeBracket+1 >= len(text) || text[closeBracket+1] != '(' {
+		return "", 0, false
+	}
+	closeParen := -1
+	depth = 1
+	for j := closeBracket + 2; j < len(text) && closeParen < 0; j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				closeParen = j
+			}
+		}
+	}
+	if closeParen < 0 {
+		return "", 0, false
+	}
+	destination := strings.TrimSpace(text[closeBracket+2 : closeParen])
+	title := ""
+	if k := strings.IndexAny(destination, " \t"); k >= 0 {
+		title = strings.TrimSpace(destination[k:])
+		destination = destination[:k]
+		if len(title) < 2 || title[0] != '"' || title[len(title)-1] != '"' {
+			return "", 0, false
+		}
+		title = title[1 : len(title)-1]
+	}
+	destination = strings.TrimSuffix(strings.TrimPrefix(destination, "<"), ">")
+
+	label := renderInline(text[i+1 : closeBracket])
+	// Links cannot contain other links, inner one wins as in CommonMark
+	if strings.Contains(label, "<a ") {
+		return "", 0, false
+	}
+	if !isSafeUrl(destination) {
+		return label, closeParen + 1, true
+	}
+	return linkTag(destination, title, label), closeParen + 1, true
+}
+
+func linkTag(url string, title string, label string) string {
+	a := `<a href="` + html.EscapeString(url) + `"`
+	if title != "" {
+		a += ` title="` + html.EscapeString(title) + `"`
+	}
+	return a + ` rel="nofollow noopener noreferrer">` + label + "</a>"
+}
+
+// Only http, https and mailto URLs and relative links are allowed
+func isSafeUrl(url string) bool {
+	if url == "" {
+		return false
+	}
+	// Browsers ignore control characters and whitespace in scheme, e.g. "java\tscript:"
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, url)
+	colon := strings.IndexByte(cleaned, ':')
+	if colon < 0 {
+		return true
+	}
+	// Colon after path, query or fragment start is not a scheme separator
+	if k := strings.IndexAny(cleaned, "/?#"); k >= 0 && k < colon {
+		return true
+	}
+	scheme := strings.ToLower(cleaned[:colon])
+	return scheme == "http" || scheme == "https" || scheme == "mailto"
+}
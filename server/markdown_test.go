@@ -0,0 +1,122 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const rel = ` rel="nofollow noopener noreferrer"`
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		// Raw HTML is always escaped
+		{"script tag", "<script>alert(1)</script>", "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>"},
+		{"event handler", `<img src=x onerror="alert(1)">`, "<p>&lt;img src=x onerror=&#34;alert(1)&#34;&gt;</p>"},
+		{"html in heading", "# head <b>", "<h1>head &lt;b&gt;</h1>"},
+		{"html in quote", "> <i>quote</i>", "<blockquote>\n<p>&lt;i&gt;quote&lt;/i&gt;</p>\n</blockquote>"},
+		{"html in list", "- <u>item</u>", "<ul>\n<li>&lt;u&gt;item&lt;/u&gt;</li>\n</ul>"},
+		{"html in code", "`<b>`", "<p><code>&lt;b&gt;</code></p>"},
+		{"entity stays text", "&lt;b&gt; &#106;", "<p>&amp;lt;b&amp;gt; &amp;#106;</p>"},
+
+		// Only http, https, mailto and relative links
+		{"http link", "[x](http://a.b/c)", `<p><a href="http://a.b/c"` + rel + `>x</a></p>`},
+		{"mailto link", "[x](mailto:a@b.c)", `<p><a href="mailto:a@b.c"` + rel + `>x</a></p>`},
+		{"relative link", "[x](/p:q)", `<p><a href="/p:q"` + rel + `>x</a></p>`},
+		{"javascript", "[x](javascript:alert(1))", "<p>x</p>"},
+		{"mixed case javascript", "[x](JaVaScRiPt:alert(1))", "<p>x</p>"},
+		{"javascript with tab", "[x](<JaVa\tScRiPt:alert(1)>)", "<p>[x](&lt;JaVa\tScRiPt:alert(1)&gt;)</p>"},
+		{"javascript with newline", "[x](java\nscript:alert(1))", "<p>x</p>"},
+		{"javascript with control character", "[x](java\x01script:alert(1))", "<p>x</p>"},
+		{"javascript with leading space", "[x](< javascript:alert(1)>)", "<p>[x](&lt; javascript:alert(1)&gt;)</p>"},
+		{"vbscript", "[x](vbscript:msgbox)", "<p>x</p>"},
+		{"data", "[x](data:text/html;base64,PHNjcmlwdD4=)", "<p>x</p>"},
+		// Entities are not decoded, so they cannot form scheme
+		{"entity encoded letter", "[x](&#106;avascript:alert(1))", `<p><a href="&amp;#106;avascript:alert(1)"` + rel + `>x</a></p>`},
+		{"entity encoded colon", "[x](javascript&#58;alert(1))", `<p><a href="javascript&amp;#58;alert(1)"` + rel + `>x</a></p>`},
+		{"named entity colon", "[x](javascript&colon;alert(1))", `<p><a href="javascript&amp;colon;alert(1)"` + rel + `>x</a></p>`},
+		{"image is link", "![alt](http://a.b/i.png)", `<p><a href="http://a.b/i.png"` + rel + `>alt</a></p>`},
+		{"autolink", "<https://a.b>", `<p><a href="https://a.b"` + rel + `>https://a.b</a></p>`},
+		{"javascript autolink", "<javascript:alert(1)>", "<p>&lt;javascript:alert(1)&gt;</p>"},
+
+		// Quotes cannot end attributes
+		{"quote in url", `[x](http://a.b"onclick=alert(1))`, `<p><a href="http://a.b&#34;onclick=alert(1)"` + rel + `>x</a></p>`},
+		{"quote in autolink", `<http://a.b/"onmouseover=x>`, `<p><a href="http://a.b/&#34;onmouseover=x"` + rel + `>http://a.b/&#34;onmouseover=x</a></p>`},
+		{"title", `[x](http://a.b "Title")`, `<p><a href="http://a.b" title="Title"` + rel + `>x</a></p>`},
+		{"quote in title", `[x](http://a.b "t" onmouseover="x")`, `<p><a href="http://a.b" title="t&#34; onmouseover=&#34;x"` + rel + `>x</a></p>`},
+		{"html in title", `[x](http://a.b "<b>&")`, `<p><a href="http://a.b" title="&lt;b&gt;&amp;"` + rel + `>x</a></p>`},
+		{"single quoted title", "[x](http://a.b 'title')", "<p>[x](http://a.b &#39;title&#39;)</p>"},
+
+		// Nesting
+		{"em in strong", "**bold *em* text**", "<p><strong>bold <em>em</em> text</strong></p>"},
+		{"strong in em", "*em **strong** em*", "<p><em>em <strong>strong</strong> em</em></p>"},
+		{"markup in link", "[**b** and `c`](http://x)", `<p><a href="http://x"` + rel + `><strong>b</strong> and <code>c</code></a></p>`},
+		{"link in strong", "**[l](http://x)**", `<p><strong><a href="http://x"` + rel + `>l</a></strong></p>`},
+		{"link in link", "[a [b](http://y)](http://x)", `<p>[a <a href="http://y"` + rel + `>b</a>](http://x)</p>`},
+		{"autolink in link", "[<http://y>](http://x)", `<p>[<a href="http://y"` + rel + `>http://y</a>](http://x)</p>`},
+		{"underscores in word", "snake_case_name", "<p>snake_case_name</p>"},
+		{"escaped delimiters", `\*not em\*`, "<p>*not em*</p>"},
+
+		// Fenced code language is a class name only
+		{"language", "```go\nx := 1 < 2\n```", `<pre><code class="language-go">x := 1 &lt; 2` + "\n</code></pre>"},
+		{"language with symbols", "```c++\nint\n```", `<pre><code class="language-c++">int` + "\n</code></pre>"},
+		{"quote in language", "```js\"onclick=alert(1)\ncode\n```", "<pre><code>code\n</code></pre>"},
+		{"attribute after language", "```js onmouseover=alert(1)\ncode\n```", `<pre><code class="language-js">code` + "\n</code></pre>"},
+		{"angle bracket in language", "```<script>\ncode\n```", "<pre><code>code\n</code></pre>"},
+
+		// Unterminated constructs stay text
+		{"unterminated fence", "```\n<b>\nno end", "<pre><code>&lt;b&gt;\nno end\n</code></pre>"},
+		{"unterminated strong", "**bold", "<p>**bold</p>"},
+		{"unterminated em", "*em", "<p>*em</p>"},
+		{"unterminated code", "`code <b>", "<p>`code &lt;b&gt;</p>"},
+		{"unterminated link", "[x](http://a.b", "<p>[x](http://a.b</p>"},
+		{"unterminated javascript link", "[x](javascript:alert(1)", "<p>[x](javascript:alert(1)</p>"},
+		{"unterminated label", "[x", "<p>[x</p>"},
+		{"unterminated autolink", "<http://a.b", "<p>&lt;http://a.b</p>"},
+		{"trailing backslash", `text\`, `<p>text\</p>`},
+	}
+	for _, test := range tests {
+		got := renderMarkdown(test.source)
+		if got != test.want {
+			t.Errorf("%v: renderMarkdown(%q)\n got  %q\n want %q", test.name, test.source, got, test.want)
+		}
+		checkSanitized(t, test.name, got)
+	}
+}
+
+var (
+	mdTagRegexp       = regexp.MustCompile(`<(/?)([a-z0-9]+)((?: [a-z]+="[^"<>]*")*)>`)
+	mdAttributeRegexp = regexp.MustCompile(` ([a-z]+)="([^"<>]*)"`)
+	mdAllowedTags     = []string{"p", "br", "h1", "h2", "h3", "h4", "h5", "h6", "pre", "code", "blockquote", "ul", "ol", "li", "hr", "em", "strong", "a"}
+)
+
+// Output contains only allowed tags with quoted allowed attributes and safe links
+func checkSanitized(t *testing.T, name string, rendered string) {
+	t.Helper()
+	rest := mdTagRegexp.ReplaceAllStringFunc(rendered, func(tag string) string {
+		m := mdTagRegexp.FindStringSubmatch(tag)
+		if !containsString(mdAllowedTags, m[2]) {
+			t.Errorf("%v: tag %v not allowed", name, tag)
+		}
+		for _, attribute := range mdAttributeRegexp.FindAllStringSubmatch(m[3], -1) {
+			switch attribute[1] {
+			case "href":
+				if !isSafeUrl(html.UnescapeString(attribute[2])) {
+					t.Errorf("%v: unsafe link %v", name, tag)
+				}
+			case "title", "rel", "class", "start":
+			default:
+				t.Errorf("%v: attribute %v not allowed", name, tag)
+			}
+		}
+		return ""
+	})
+	if strings.ContainsAny(rest, "<>") {
+		t.Errorf("%v: unescaped markup in %q", name, rendered)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// At most this many users are mentioned in one post, comment or message
+const MAX_MENTIONS = 10
+
+var mentionRegexp = regexp.MustCompile(`@([\p{L}\p{N}_.\-]+)`)
+
+// Find @nick_name references of existing users. @ preceded by letter or digit,
+// e.g. in email address, is not a mention. Trailing dots and dashes are ignored
+// unless they are part of nick name
+func parseMentions(content string) ([]Mention, error) {
+	mentions := []Mention{}
+	seen := map[int]bool{}
+	for _, match := range mentionRegexp.FindAllStringSubmatchIndex(content, -1) {
+		start := match[0]
+		if start > 0 {
+			r, _ := utf8.DecodeLastRuneInString(content[:start])
+			if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '@' {
+				continue
+			}
+		}
+		nickName := content[match[2]:match[3]]
+		userId, err := getUserIdByNickName(nickName)
+		if err != nil {
+			return nil, err
+		}
+		if userId == 0 {
+			nickName = strings.TrimRight(nickName, ".-")
+			if nickName == "" {
+				continue
+			}
+			userId, err = getUserIdByNickName(nickName)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if userId == 0 || seen[userId] {
+			continue
+		}
+		seen[userId] = true
+		mentions = append(mentions, Mention{
+			UserId:   userId,
+			NickName: nickName,
+			Offset:   utf16Length(content[:start]),
+			Length:   utf16Length("@" + nickName),
+		})
+		if len(mentions) == MAX_MENTIONS {
+			break
+		}
+	}
+	return mentions, nil
+}
+
+func utf16Length(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// Parse and store mentions of saved content
+func saveMentions(contentType string, contentId int, content string) ([]Mention, error) {
+	mentions, err := parseMentions(content)
+	if err != nil {
+		return nil, err
+	}
+	err = insertMentions(contentType, contentId, mentions)
+	if err != nil {
+		return nil, err
+	}
+	return mentions, nil
+}
+
+// Mentioned users are notified about posts and comments, which everybody can read.
+// Mentions in private messages are not notified since only recipient can see them.
+// Users in notified already got notification about the same content, notified users are added to it
+func notifyMentions(user *User, mentions []Mention, postId int, commentId int, content string, notified map[int]bool) error {
+	for _, mention := range mentions {
+		if notified[mention.UserId] {
+			continue
+		}
+		err := notify(Notification{
+			UserId:        mention.UserId,
+			Type:          NOTIFICATION_MENTION,
+			ActorId:       user.Id,
+			ActorNickName: user.NickName,
+			PostId:        postId,
+			CommentId:     commentId,
+			Content:       content,
+		})
+		if err != nil {
+			return err
+		}
+		if notified != nil {
+			notified[mention.UserId] = true
+		}
+	}
+	return nil
+}
@@ -14,16 +14,33 @@ type User struct {
 	Password2 string `json:"password2"`
 	SessionId string `json:"session_id"`
 	OnLine    bool   `json:"on_line"`
+	Verified  bool   `json:"verified"`
+	Role      string `json:"role"`
+	Avatar    string `json:"-"`
+	AvatarUrl string `json:"avatar_url"`
 }
 
 type Post struct {
-	Id               int      `json:"id"`
-	Date             int      `json:"date"`
-	UserId           int      `json:"user_id"`
-	NickName         string   `json:"nick_name"`
-	Content          string   `json:"content"`
-	Categories       []string `json:"categories"`
-	NumberOfComments int      `json:"number_of_comments"`
+	Id               int           `json:"id"`
+	Date             int           `json:"date"`
+	UserId           int           `json:"user_id"`
+	NickName         string        `json:"nick_name"`
+	AvatarUrl        string        `json:"avatar_url"`
+	Title            string        `json:"title"`
+	Content          string        `json:"content"`
+	ContentHtml      string        `json:"content_html"`
+	Categories       []string      `json:"categories"`
+	NumberOfComments int           `json:"number_of_comments"`
+	Mentions         []Mention     `json:"mentions"`
+	Attachments      []Attachment  `json:"attachments"`
+	LinkPreviews     []LinkPreview `json:"link_previews"`
+	Pinned           bool          `json:"pinned"`
+	PinnedCategory   string        `json:"pinned_category"`
+	Locked           bool          `json:"locked"`
+	PublishAt        int64         `json:"publish_at,omitempty"`
+	Poll             *Poll         `json:"poll,omitempty"`
+	Bookmarked       bool          `json:"bookmarked"`
+	Subscribed       bool          `json:"subscribed"`
 }
 
 type Error struct {
@@ -58,21 +75,47 @@ type MessageWrapper struct {
 }
 
 type Message struct {
-	Id           int    `json:"id"`
+	Id           int           `json:"id"`
+	FromId       int           `json:"from_id"`
+	FromNickName string        `json:"from_nick_name"`
+	ToId         int           `json:"to_id"`
+	Content      string        `json:"content"`
+	ContentHtml  string        `json:"content_html"`
+	Date         int64         `json:"date"`
+	Pending      bool          `json:"pending"`
+	Mentions     []Mention     `json:"mentions"`
+	Attachments  []Attachment  `json:"attachments"`
+	LinkPreviews []LinkPreview `json:"link_previews"`
+	PublishAt    int64         `json:"publish_at,omitempty"`
+}
+
+// Messages from user who is not a contact of recipient
+type MessageRequest struct {
 	FromId       int    `json:"from_id"`
 	FromNickName string `json:"from_nick_name"`
-	ToId         int    `json:"to_id"`
-	Content      string `json:"content"`
+	Count        int    `json:"count"`
 	Date         int64  `json:"date"`
+	Content      string `json:"content"`
+}
+
+type MessageRequestWrapper struct {
+	MessageRequest MessageRequest `json:"message_request"`
 }
 
 type Comment struct {
-	Id           int    `json:"id"`
-	Date         int    `json:"date"`
-	UserId       int    `json:"user_id"`
-	UserNickName string `json:"user_nick_name"`
-	PostId       int    `json:"post_id"`
-	Content      string `json:"content"`
+	Id            int           `json:"id"`
+	Date          int           `json:"date"`
+	UserId        int           `json:"user_id"`
+	UserNickName  string        `json:"user_nick_name"`
+	UserAvatarUrl string        `json:"user_avatar_url"`
+	PostId        int           `json:"post_id"`
+	Content       string        `json:"content"`
+	ContentHtml   string        `json:"content_html"`
+	ParentId      int           `json:"parent_id"`
+	Mentions      []Mention     `json:"mentions"`
+	Attachments   []Attachment  `json:"attachments"`
+	LinkPreviews  []LinkPreview `json:"link_previews"`
+	Bookmarked    bool          `json:"bookmarked"`
 	//Username     string `json:"username"`
 }
 
@@ -86,3 +129,249 @@ type NewPostPageObject struct {
 	User       *User  `json:"user"`
 	Categories string `json:"categories"`
 }
+
+type Privacy struct {
+	FirstName bool `json:"first_name"`
+	LastName  bool `json:"last_name"`
+	Age       bool `json:"age"`
+	Gender    bool `json:"gender"`
+	Email     bool `json:"email"`
+	// Only contacts can message user directly, others send message requests
+	ContactsOnly bool `json:"contacts_only"`
+}
+
+type Profile struct {
+	User             *User    `json:"user"`
+	Privacy          *Privacy `json:"privacy"`
+	JoinDate         int64    `json:"join_date"`
+	NumberOfPosts    int      `json:"number_of_posts"`
+	NumberOfComments int      `json:"number_of_comments"`
+	Followers        int      `json:"followers"`
+	Following        int      `json:"following"`
+	// Signed in user follows this user
+	Followed bool `json:"followed"`
+}
+
+type TOTP struct {
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+type TwoFactorChallenge struct {
+	Challenge string `json:"challenge"`
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+type Report struct {
+	Id               int    `json:"id"`
+	ContentType      string `json:"content_type"`
+	ContentId        int    `json:"content_id"`
+	Content          string `json:"content"`
+	AuthorId         int    `json:"author_id"`
+	AuthorNickName   string `json:"author_nick_name"`
+	Hidden           bool   `json:"hidden"`
+	ReporterId       int    `json:"reporter_id"`
+	ReporterNickName string `json:"reporter_nick_name"`
+	Reason           string `json:"reason"`
+	Date             int64  `json:"date"`
+	Status           string `json:"status"`
+}
+
+type Warning struct {
+	Reason string `json:"reason"`
+	Date   int64  `json:"date"`
+}
+
+type WarningWrapper struct {
+	Warning Warning `json:"warning"`
+}
+
+type Sanction struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id"`
+	Type        string `json:"type"`
+	Reason      string `json:"reason"`
+	Expires     int64  `json:"expires"`
+	ModeratorId int    `json:"moderator_id"`
+	Date        int64  `json:"date"`
+}
+
+type SanctionWrapper struct {
+	Sanction Sanction `json:"sanction"`
+}
+
+type IpBan struct {
+	Id          int    `json:"id"`
+	Cidr        string `json:"cidr"`
+	Reason      string `json:"reason"`
+	Expires     int64  `json:"expires"`
+	ModeratorId int    `json:"moderator_id"`
+	Date        int64  `json:"date"`
+}
+
+type Stats struct {
+	Users           int `json:"users"`
+	Admins          int `json:"admins"`
+	Moderators      int `json:"moderators"`
+	Unverified      int `json:"unverified"`
+	Sessions        int `json:"sessions"`
+	Posts           int `json:"posts"`
+	Comments        int `json:"comments"`
+	Messages        int `json:"messages"`
+	Categories      int `json:"categories"`
+	OpenReports     int `json:"open_reports"`
+	ActiveSanctions int `json:"active_sanctions"`
+}
+
+type Notification struct {
+	Id            int    `json:"id"`
+	UserId        int    `json:"user_id"`
+	Type          string `json:"type"`
+	ActorId       int    `json:"actor_id"`
+	ActorNickName string `json:"actor_nick_name"`
+	PostId        int    `json:"post_id"`
+	CommentId     int    `json:"comment_id"`
+	Content       string `json:"content"`
+	Date          int64  `json:"date"`
+	Read          bool   `json:"read"`
+
+	// Stored without being pushed to user
+	quiet bool
+}
+
+type NotificationWrapper struct {
+	Notification Notification `json:"notification"`
+}
+
+// @nick_name in content. Offset and length are in UTF-16 code units
+type Mention struct {
+	UserId   int    `json:"user_id"`
+	NickName string `json:"nick_name"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
+
+type Attachment struct {
+	Id           int    `json:"id"`
+	FileName     string `json:"file_name"`
+	MimeType     string `json:"mime_type"`
+	Size         int    `json:"size"`
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	Url          string `json:"url"`
+	ThumbnailUrl string `json:"thumbnail_url,omitempty"`
+
+	UserId       int    `json:"-"`
+	ContentType  string `json:"-"`
+	ContentId    int    `json:"-"`
+	StorageKey   string `json:"-"`
+	ThumbnailKey string `json:"-"`
+}
+
+// Bytes of attachments uploaded by user and how many bytes user may upload
+type StorageUsage struct {
+	Used  int `json:"used"`
+	Quota int `json:"quota"`
+}
+
+// OpenGraph or Twitter card metadata of page linked in content
+type LinkPreview struct {
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Image       string `json:"image"`
+	SiteName    string `json:"site_name"`
+}
+
+// Preview of link in message, pushed when it has been fetched
+type MessageLinkPreview struct {
+	MessageId   int         `json:"message_id"`
+	LinkPreview LinkPreview `json:"link_preview"`
+}
+
+type MessageLinkPreviewWrapper struct {
+	MessageLinkPreview MessageLinkPreview `json:"message_link_preview"`
+}
+
+// Unpublished post, comment or message, saved while it is being written.
+// Title and categories are used only by post drafts
+type Draft struct {
+	Target     string   `json:"target"`
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Categories []string `json:"categories"`
+	Version    int      `json:"version"`
+	Date       int64    `json:"date"`
+}
+
+// Posts and messages of user waiting to be published
+type ScheduledContent struct {
+	Posts    []Post    `json:"posts"`
+	Messages []Message `json:"messages"`
+}
+
+// Poll of post with its results. Voted holds option ids the requesting user
+// voted for, it is null in live updates which every viewer of post gets
+type Poll struct {
+	Question  string       `json:"question"`
+	Multiple  bool         `json:"multiple"`
+	Anonymous bool         `json:"anonymous"`
+	ClosesAt  int64        `json:"closes_at"`
+	Closed    bool         `json:"closed"`
+	Options   []PollOption `json:"options"`
+	Voters    int          `json:"voters"`
+	Voted     []int        `json:"voted"`
+}
+
+// Voters is left out for anonymous polls
+type PollOption struct {
+	Id     int         `json:"id"`
+	Text   string      `json:"text"`
+	Votes  int         `json:"votes"`
+	Voters []PollVoter `json:"voters,omitempty"`
+}
+
+type PollVoter struct {
+	Id       int    `json:"id"`
+	NickName string `json:"nick_name"`
+}
+
+// Bookmarked post or comment. Post or Comment is set depending on ContentType
+type Bookmark struct {
+	ContentType  string   `json:"content_type"`
+	ContentId    int      `json:"content_id"`
+	CollectionId int      `json:"collection_id"`
+	Date         int64    `json:"date"`
+	Post         *Post    `json:"post,omitempty"`
+	Comment      *Comment `json:"comment,omitempty"`
+}
+
+// Named group of bookmarks of one user
+type BookmarkCollection struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// Users and categories followed by user
+type Following struct {
+	Users      []*User  `json:"users"`
+	Categories []string `json:"categories"`
+}
+
+// Subscription of user to comments of post
+type Subscription struct {
+	PostId int   `json:"post_id"`
+	Push   bool  `json:"push"`
+	Date   int64 `json:"date"`
+	Post   *Post `json:"post"`
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content types that can be reported
+const CONTENT_POST = "post"
+const CONTENT_COMMENT = "comment"
+const CONTENT_MESSAGE = "message"
+
+// Report statuses. Every status except open is set by a moderator action
+const REPORT_OPEN = "open"
+const REPORT_DISMISSED = "dismissed"
+const REPORT_HIDDEN = "hidden"
+const REPORT_DELETED = "deleted"
+const REPORT_WARNED = "warned"
+
+// Moderator actions and report status each of them sets
+var moderationActions = map[string]string{
+	"dismiss": REPORT_DISMISSED,
+	"hide":    REPORT_HIDDEN,
+	"delete":  REPORT_DELETED,
+	"warn":    REPORT_WARNED,
+}
+
+// Report post, comment or private message
+func reportHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, err := getUserBySessionId(r.FormValue("session_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if user == nil {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	report := Report{
+		ContentType: r.FormValue("content_type"),
+		ReporterId:  user.Id,
+		Reason:      strings.TrimSpace(r.FormValue("reason")),
+	}
+	if _, ok := contentTables[report.ContentType]; !ok {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: unknown content type"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	report.ContentId, err = strconv.Atoi(r.FormValue("content_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if len(report.Reason) == 0 || len(report.Reason) > 500 {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: reason should be between 1 and 500 characters long"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Error = checkReportable(user, &report)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = insertReport(&report)
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
+			resp.Error = &Error{Type: ALREADY_REPORTED, Message: "Error: you have already reported this"}
+		} else {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Hide content reported by many users until moderator looks at it
+	if config.ReportHideThreshold > 0 {
+		count, err := countOpenReports(report.ContentType, report.ContentId)
+		if err == nil && count >= config.ReportHideThreshold {
+			event := removalEvent(report.ContentType, report.ContentId)
+			err = setContentHidden(report.ContentType, report.ContentId, true)
+			if err == nil {
+				publishFeedEvent(event)
+			}
+		}
+		if err != nil {
+			errorHandler(err)
+		}
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Content must exist and not belong to reporter. Messages can be reported only by their recipient
+func checkReportable(user *User, report *Report) *Error {
+	err := getReportedContent(report)
+	if err != nil {
+		return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if report.AuthorId == 0 {
+		return &Error{Type: CONTENT_NOT_FOUND, Message: "Error: content not found"}
+	}
+	if report.AuthorId == user.Id {
+		return &Error{Type: INVALID_INPUT, Message: "Error: you cannot report your own content"}
+	}
+	if report.ContentType == CONTENT_MESSAGE {
+		message, err := getMessage(report.ContentId)
+		if err != nil {
+			return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		}
+		if message == nil || message.ToId != user.Id {
+			return &Error{Type: CONTENT_NOT_FOUND, Message: "Error: content not found"}
+		}
+	}
+	return nil
+}
+
+// Moderation queue: open reports with reported content and reporter
+func reportsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	reports, err := getOpenReports()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = reports
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Apply action to reported content. All open reports about the content are closed
+func moderateHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	reportId, err := strconv.Atoi(r.FormValue("report_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	action := r.FormValue("action")
+	status, ok := moderationActions[action]
+	if !ok {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: unknown action"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+
+	report, err := getReport(reportId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if report == nil || report.Status != REPORT_OPEN {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: no such open report"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if report.AuthorId == 0 && status == REPORT_WARNED {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: content was deleted"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Hidden and deleted content disappears from feed of connected clients
+	event := removalEvent(report.ContentType, report.ContentId)
+	switch status {
+	case REPORT_DISMISSED:
+		// Undo automatic hiding
+		err = setContentHidden(report.ContentType, report.ContentId, false)
+	case REPORT_HIDDEN:
+		err = setContentHidden(report.ContentType, report.ContentId, true)
+	case REPORT_DELETED:
+		err = deleteContent(report.ContentType, report.ContentId)
+	case REPORT_WARNED:
+		if note == "" {
+			note = report.Reason
+		}
+		err = warnUser(report.AuthorId, user.Id, note)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if status == REPORT_HIDDEN || status == REPORT_DELETED {
+		publishFeedEvent(event)
+	}
+
+	err = resolveReports(report.ContentType, report.ContentId, status, user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func deleteContent(contentType string, contentId int) error {
+	switch contentType {
+	case CONTENT_POST:
+		return deletePost(contentId)
+	case CONTENT_COMMENT:
+		return deleteComment(contentId)
+	case CONTENT_MESSAGE:
+		return deleteMessage(contentId)
+	}
+	return fmt.Errorf("unknown content type %v", contentType)
+}
+
+// Record warning and show it to user if online
+func warnUser(userId int, moderatorId int, reason string) error {
+	err := insertWarning(userId, moderatorId, reason)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(WarningWrapper{Warning{Reason: reason, Date: getCurrentMilli()}})
+	if err != nil {
+		return err
+	}
+	notifyClient(userId, b)
+	return nil
+}
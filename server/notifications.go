@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Notification types
+const NOTIFICATION_COMMENT = "comment"
+const NOTIFICATION_REPLY = "reply"
+const NOTIFICATION_MENTION = "mention"
+const NOTIFICATION_MESSAGE = "message"
+const NOTIFICATION_MESSAGE_REQUEST = "message_request"
+const NOTIFICATION_FOLLOW = "follow"
+const NOTIFICATION_SUBSCRIPTION = "subscription"
+
+// Length of content excerpt stored with notification
+const NOTIFICATION_CONTENT_LENGTH = 100
+
+// Record notification and push it to user if online, unless notification is quiet.
+// Users are not notified about own actions or actions of users they blocked
+func notify(notification Notification) error {
+	if notification.UserId == notification.ActorId {
+		return nil
+	}
+	blocked, err := isBlocked(notification.UserId, notification.ActorId)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return nil
+	}
+
+	notification.Content = excerpt(notification.Content, NOTIFICATION_CONTENT_LENGTH)
+	notification.Date = getCurrentMilli()
+	err = insertNotification(&notification)
+	if err != nil {
+		return err
+	}
+	if notification.quiet {
+		return nil
+	}
+
+	b, err := json.Marshal(NotificationWrapper{notification})
+	if err != nil {
+		return err
+	}
+	notifyClient(notification.UserId, b)
+	return nil
+}
+
+// Notify author of post and, for replies, author of parent comment.
+// Returns ids of notified users
+func notifyComment(user *User, post *Post, comment *Comment) (map[int]bool, error) {
+	n := Notification{
+		Type:          NOTIFICATION_COMMENT,
+		ActorId:       user.Id,
+		ActorNickName: user.NickName,
+		PostId:        post.Id,
+		CommentId:     comment.Id,
+		Content:       comment.Content,
+	}
+
+	notified := map[int]bool{}
+	if comment.ParentId != 0 {
+		parent, err := getComment(comment.ParentId)
+		if err != nil {
+			return notified, err
+		}
+		if parent != nil {
+			reply := n
+			reply.Type = NOTIFICATION_REPLY
+			reply.UserId = parent.UserId
+			err = notify(reply)
+			if err != nil {
+				return notified, err
+			}
+			notified[parent.UserId] = true
+		}
+	}
+
+	// Author of post who is also author of parent comment gets only the reply
+	if notified[post.UserId] {
+		return notified, nil
+	}
+	n.UserId = post.UserId
+	err := notify(n)
+	if err != nil {
+		return notified, err
+	}
+	notified[post.UserId] = true
+	return notified, nil
+}
+
+// Notify subscribers of post about new comment, except users in notified
+// who already got notification about it
+func notifySubscribers(user *User, post *Post, comment *Comment, notified map[int]bool) error {
+	subscribers, err := getSubscribers(post.Id)
+	if err != nil {
+		return err
+	}
+	for userId, push := range subscribers {
+		if notified[userId] {
+			continue
+		}
+		err = notify(Notification{
+			UserId:        userId,
+			Type:          NOTIFICATION_SUBSCRIPTION,
+			ActorId:       user.Id,
+			ActorNickName: user.NickName,
+			PostId:        post.Id,
+			CommentId:     comment.Id,
+			Content:       comment.Content,
+			quiet:         !push,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Messages are notified only to users who are not connected
+func notifyMessage(message *Message) error {
+	if _, ok := getClient(message.ToId); ok {
+		return nil
+	}
+	n := Notification{
+		UserId:        message.ToId,
+		Type:          NOTIFICATION_MESSAGE,
+		ActorId:       message.FromId,
+		ActorNickName: message.FromNickName,
+		Content:       message.Content,
+	}
+	if message.Pending {
+		n.Type = NOTIFICATION_MESSAGE_REQUEST
+	}
+	return notify(n)
+}
+
+// Notifications of signed in user, page by page
+func notificationsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	page := 1
+	if r.FormValue("page") != "" {
+		p, err := strconv.Atoi(r.FormValue("page"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if p > 0 {
+			page = p
+		}
+	}
+
+	notifications, err := getNotifications(user.Id, page)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = notifications
+	json.NewEncoder(w).Encode(resp)
+}
+
+func unreadNotificationsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	count, err := countUnreadNotifications(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = count
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Mark notification_id as read, or all notifications if notification_id is missing
+func readNotificationsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var err error
+	if r.FormValue("notification_id") == "" {
+		err = markAllNotificationsRead(user.Id)
+	} else {
+		var id int
+		id, err = strconv.Atoi(r.FormValue("notification_id"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		err = markNotificationRead(user.Id, id)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenID Connect sign in with authorization code flow and PKCE.
+// Users with two-factor authentication on still have to pass /signintotp,
+// identity provider does not replace forum second factor.
+
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type oidcKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type oidcClaims struct {
+	Issuer            string      `json:"iss"`
+	Subject           string      `json:"sub"`
+	Audience          interface{} `json:"aud"`
+	Expires           int64       `json:"exp"`
+	Nonce             string      `json:"nonce"`
+	Email             string      `json:"email"`
+	EmailVerified     bool        `json:"email_verified"`
+	PreferredUsername string      `json:"preferred_username"`
+	GivenName         string      `json:"given_name"`
+	FamilyName        string      `json:"family_name"`
+	Name              string      `json:"name"`
+}
+
+// Sign in attempt waiting for provider to redirect back
+type oidcState struct {
+	verifier string
+	nonce    string
+	expires  int64
+}
+
+var oidcStates = make(map[string]oidcState)
+var oidcMutex sync.Mutex
+var oidcProviderCache *oidcProvider
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+var nickNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// Redirect browser to identity provider
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if config.OidcIssuer == "" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: sign in with identity provider is not configured"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	provider, err := getOidcProvider()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_READING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	state, err1 := generateToken()
+	nonce, err2 := generateToken()
+	verifier, err3 := generateToken()
+	if err1 != nil || err2 != nil || err3 != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: "Error: unable to generate state"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	saveOidcState(state, oidcState{verifier: verifier, nonce: nonce, expires: getCurrentMilli() + 10*60*1000})
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", config.OidcClientId)
+	params.Set("redirect_uri", config.oidcRedirectUrl())
+	params.Set("scope", config.OidcScopes)
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	http.Redirect(w, r, provider.AuthorizationEndpoint+separator+params.Encode(), http.StatusFound)
+}
+
+// Provider redirects here with authorization code. On success session cookie
+// is set the same way the client does it and browser goes to main page.
+// Users with two-factor authentication go to main page with totp_challenge
+// to be sent to /signintotp with their code instead
+func oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+
+	if checkIpAllowed(r) != nil {
+		oidcFail(w, r, IP_BANNED)
+		return
+	}
+
+	state, ok := takeOidcState(query.Get("state"))
+	if !ok {
+		oidcFail(w, r, "invalid_state")
+		return
+	}
+	if query.Get("error") != "" {
+		oidcFail(w, r, query.Get("error"))
+		return
+	}
+
+	provider, err := getOidcProvider()
+	if err != nil {
+		errorHandler(err)
+		oidcFail(w, r, "provider_unavailable")
+		return
+	}
+
+	claims, err := exchangeOidcCode(provider, query.Get("code"), state)
+	if err != nil {
+		errorHandler(err)
+		oidcFail(w, r, "invalid_token")
+		return
+	}
+
+	user, err := oidcUser(claims)
+	if err != nil {
+		errorHandler(err)
+		oidcFail(w, r, "unable_to_link_account")
+		return
+	}
+
+	totp, err := getTOTP(user.Id)
+	if err != nil {
+		errorHandler(err)
+		oidcFail(w, r, ERROR_ACCESSING_DATABASE)
+		return
+	}
+	if totp.Enabled {
+		challenge, err := newTOTPChallenge(user.Id)
+		if err != nil {
+			errorHandler(err)
+			oidcFail(w, r, ERROR_ACCESSING_DATABASE)
+			return
+		}
+		http.Redirect(w, r, "/?"+url.Values{"totp_challenge": {challenge}}.Encode(), http.StatusFound)
+		return
+	}
+
+	_, e := startSession(user)
+	if e != nil {
+		errorHandler(errors.New(e.Message))
+		oidcFail(w, r, e.Type)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    "JSESSIONID",
+		Value:   user.SessionId,
+		Path:    "/",
+		Expires: time.Now().Add(24 * time.Hour),
+	})
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func oidcFail(w http.ResponseWriter, r *http.Request, reason string) {
+	http.Redirect(w, r, "/?"+url.Values{"oidc_error": {reason}}.Encode(), http.StatusFound)
+}
+
+func saveOidcState(state string, s oidcState) {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	now := getCurrentMilli()
+	for key, value := range oidcStates {
+		if value.expires < now {
+			delete(oidcStates, key)
+		}
+	}
+	oidcStates[state] = s
+}
+
+// State can be used only once
+func takeOidcState(state string) (oidcState, bool) {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	s, ok := oidcStates[state]
+	delete(oidcStates, state)
+	if !ok || s.expires < getCurrentMilli() {
+		return oidcState{}, false
+	}
+	return s, true
+}
+
+func getOidcProvider() (*oidcProvider, error) {
+	oidcMutex.Lock()
+	cached := oidcProviderCache
+	oidcMutex.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	provider := oidcProvider{}
+	err := getJson(strings.TrimSuffix(config.OidcIssuer, "/")+"/.well-known/openid-configuration", &provider)
+	if err != nil {
+		return nil, err
+	}
+	if provider.Issuer != config.OidcIssuer {
+		return nil, fmt.Errorf("issuer mismatch: %v", provider.Issuer)
+	}
+
+	oidcMutex.Lock()
+	oidcProviderCache = &provider
+	oidcMutex.Unlock()
+	return &provider, nil
+}
+
+func getJson(address string, v interface{}) error {
+	resp, err := oidcClient.Get(address)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v returned %v", address, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Exchange authorization code for ID token and return its verified claims
+func exchangeOidcCode(provider *oidcProvider, code string, state oidcState) (*oidcClaims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", config.oidcRedirectUrl())
+	form.Set("client_id", config.OidcClientId)
+	form.Set("code_verifier", state.verifier)
+
+	request, err := http.NewRequest("POST", provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if config.OidcClientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(config.OidcClientId), url.QueryEscape(config.OidcClientSecret))
+	}
+
+	resp, err := oidcClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %v", resp.Status)
+	}
+
+	token := struct {
+		IdToken string `json:"id_token"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := verifyIdToken(provider, token.IdToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != state.nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	return claims, nil
+}
+
+// Check RS256 signature, issuer, audience and expiry of ID token
+func verifyIdToken(provider *oidcProvider, idToken string) (*oidcClaims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id token")
+	}
+
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	err := decodeJwtPart(parts[0], &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported signing algorithm %v", header.Alg)
+	}
+
+	key, err := getOidcKey(provider, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := oidcClaims{}
+	err = decodeJwtPart(parts[1], &claims)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Issuer != provider.Issuer {
+		return nil, errors.New("issuer mismatch")
+	}
+	if !oidcAudienceContains(claims.Audience, config.OidcClientId) {
+		return nil, errors.New("audience mismatch")
+	}
+	if claims.Expires < time.Now().Unix() {
+		return nil, errors.New("id token expired")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return &claims, nil
+}
+
+func decodeJwtPart(part string, v interface{}) error {
+	bytes, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bytes, v)
+}
+
+func oidcAudienceContains(audience interface{}, clientId string) bool {
+	switch aud := audience.(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Keys are fetched on every sign in so rotated keys are picked up
+func getOidcKey(provider *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	jwks := struct {
+		Keys []oidcKey `json:"keys"`
+	}{}
+	err := getJson(provider.JwksUri, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" || (kid != "" && key.Kid != kid) {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	}
+	return nil, fmt.Errorf("no key %v", kid)
+}
+
+// Find user linked to identity. On first sign in identity is linked to user
+// with the same verified email, or a new user is created. Unverified account
+// with the same email is not linked, anyone could have signed up with it
+func oidcUser(claims *oidcClaims) (*User, error) {
+	userId, err := getIdentityUserId(claims.Issuer, claims.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userId != -1 {
+		return getUserById(userId)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(claims.Email))
+	if email == "" {
+		return nil, errors.New("identity provider did not return email")
+	}
+
+	if claims.EmailVerified {
+		user, err := getUserByEmailOrNickName(email)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil && user.Email == email {
+			if !user.Verified {
+				return nil, errors.New("email belongs to unverified account")
+			}
+			err = insertIdentity(claims.Issuer, claims.Subject, user.Id)
+			if err != nil {
+				return nil, err
+			}
+			return user, nil
+		}
+	}
+
+	nickName, err := uniqueNickName(claims)
+	if err != nil {
+		return nil, err
+	}
+	// Password is unknown to user. It can be set later with password reset
+	password, err := generateToken()
+	if err != nil {
+		return nil, err
+	}
+	user := User{
+		FirstName: claims.GivenName,
+		LastName:  claims.FamilyName,
+		Gender:    "Prefer Not To Say",
+		NickName:  nickName,
+		Email:     email,
+		Password:  encrypt(password),
+		Verified:  claims.EmailVerified,
+	}
+	id, err := saveUser(&user)
+	if err != nil {
+		return nil, err
+	}
+	user.Id = int(id)
+	err = insertIdentity(claims.Issuer, claims.Subject, user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Nick name based on provider user name, email or name, with number added if taken
+func uniqueNickName(claims *oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = strings.Split(claims.Email, "@")[0]
+	}
+	if base == "" {
+		base = claims.Name
+	}
+	base = strings.Trim(nickNameChars.ReplaceAllString(base, "_"), "_")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	for len(base) < 2 {
+		base += "_"
+	}
+
+	nickName := base
+	for i := 2; ; i++ {
+		taken, err := isNickNameTaken(nickName)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return nickName, nil
+		}
+		nickName = fmt.Sprintf("%v%v", base, i)
+	}
+}
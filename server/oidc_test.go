@@ -0,0 +1,321 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Identity provider running in test process. Codes are issued by authorize
+// instead of a login page, token endpoint enforces PKCE
+type mockProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	mutex  sync.Mutex
+	codes  map[string]mockCode
+}
+
+type mockCode struct {
+	challenge string
+	nonce     string
+	claims    oidcClaims
+}
+
+const mockClientId = "forum"
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &mockProvider{key: key, codes: map[string]mockCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcProvider{
+			Issuer:                p.server.URL,
+			AuthorizationEndpoint: p.server.URL + "/authorize",
+			TokenEndpoint:         p.server.URL + "/token",
+			JwksUri:               p.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string][]oidcKey{"keys": {{
+			Kid: "test",
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", p.token)
+	p.server = httptest.NewServer(mux)
+
+	previous := config
+	config.OidcIssuer = p.server.URL
+	config.OidcClientId = mockClientId
+	config.OidcClientSecret = ""
+	config.BaseUrl = "http://forum.test"
+	oidcProviderCache = nil
+	t.Cleanup(func() {
+		p.server.Close()
+		config = previous
+		oidcProviderCache = nil
+	})
+	return p
+}
+
+// Sign in at provider with claims, as done by user on provider login page.
+// Returns code bound to PKCE challenge and nonce of authorization request
+func (p *mockProvider) authorize(t *testing.T, authorizeUrl string, claims oidcClaims) string {
+	t.Helper()
+	u, err := url.Parse(authorizeUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != mockClientId || q.Get("redirect_uri") != config.oidcRedirectUrl() {
+		t.Fatalf("bad authorization request %v", authorizeUrl)
+	}
+	code, err := generateToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.mutex.Lock()
+	p.codes[code] = mockCode{challenge: q.Get("code_challenge"), nonce: q.Get("nonce"), claims: claims}
+	p.mutex.Unlock()
+	return code
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mutex.Lock()
+	code, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mutex.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("client_id") != mockClientId ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	claims := code.claims
+	claims.Issuer = p.server.URL
+	claims.Audience = mockClientId
+	claims.Expires = time.Now().Add(5 * time.Minute).Unix()
+	if claims.Nonce == "" {
+		claims.Nonce = code.nonce
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(claims)})
+}
+
+func (p *mockProvider) sign(claims oidcClaims) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, hash[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// Start sign in at forum, returns provider authorization url
+func oidcLogin(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	oidcLoginHandler(w, httptest.NewRequest("GET", "/oidclogin", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: status %v %v", w.Code, w.Body.String())
+	}
+	return w.Header().Get("Location")
+}
+
+// Return from provider to forum. Returns redirect target and session cookie, if any
+func oidcCallback(t *testing.T, state string, code string) (*url.URL, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	target := "/oidccallback?" + url.Values{"state": {state}, "code": {code}}.Encode()
+	oidcCallbackHandler(w, httptest.NewRequest("GET", target, nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback: status %v", w.Code)
+	}
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := ""
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == "JSESSIONID" {
+			session = cookie.Value
+		}
+	}
+	return location, session
+}
+
+// Full sign in with claims returned by provider
+func oidcSignIn(t *testing.T, p *mockProvider, claims oidcClaims) (*url.URL, string) {
+	t.Helper()
+	authorizeUrl := oidcLogin(t)
+	code := p.authorize(t, authorizeUrl, claims)
+	u, _ := url.Parse(authorizeUrl)
+	return oidcCallback(t, u.Query().Get("state"), code)
+}
+
+func queryOf(authorizeUrl string, key string) string {
+	u, _ := url.Parse(authorizeUrl)
+	return u.Query().Get(key)
+}
+
+func TestOidcState(t *testing.T) {
+	setupTestDB(t)
+	p := newMockProvider(t)
+	claims := oidcClaims{Subject: "s1", Email: "state@example.com", EmailVerified: true}
+
+	authorizeUrl := oidcLogin(t)
+	code := p.authorize(t, authorizeUrl, claims)
+	location, session := oidcCallback(t, "forged", code)
+	if location.Query().Get("oidc_error") != "invalid_state" || session != "" {
+		t.Fatalf("unknown state: %v", location)
+	}
+
+	state := queryOf(authorizeUrl, "state")
+	location, session = oidcCallback(t, state, code)
+	if location.Query().Get("oidc_error") != "" || session == "" {
+		t.Fatalf("valid state: %v", location)
+	}
+
+	// State is single use
+	location, session = oidcCallback(t, state, p.authorize(t, authorizeUrl, claims))
+	if location.Query().Get("oidc_error") != "invalid_state" || session != "" {
+		t.Fatalf("reused state: %v", location)
+	}
+}
+
+func TestOidcPKCE(t *testing.T) {
+	setupTestDB(t)
+	p := newMockProvider(t)
+	claims := oidcClaims{Subject: "s1", Email: "pkce@example.com", EmailVerified: true}
+
+	// Code issued for another sign in attempt has another challenge
+	first := oidcLogin(t)
+	second := oidcLogin(t)
+	if queryOf(first, "code_challenge") == queryOf(second, "code_challenge") {
+		t.Fatal("code challenge is reused")
+	}
+	stolen := p.authorize(t, first, claims)
+	location, session := oidcCallback(t, queryOf(second, "state"), stolen)
+	if location.Query().Get("oidc_error") != "invalid_token" || session != "" {
+		t.Fatalf("code of other attempt: %v", location)
+	}
+}
+
+func TestOidcNonce(t *testing.T) {
+	setupTestDB(t)
+	p := newMockProvider(t)
+	claims := oidcClaims{Subject: "s1", Email: "nonce@example.com", EmailVerified: true, Nonce: "replayed"}
+
+	location, session := oidcSignIn(t, p, claims)
+	if location.Query().Get("oidc_error") != "invalid_token" || session != "" {
+		t.Fatalf("wrong nonce: %v", location)
+	}
+}
+
+func TestOidcLinkAndCreate(t *testing.T) {
+	setupTestDB(t)
+	p := newMockProvider(t)
+	existing := createTestUser(t, "linked")
+
+	// Verified email links identity to existing user
+	_, session := oidcSignIn(t, p, oidcClaims{Subject: "s1", Email: "LINKED@example.com", EmailVerified: true})
+	user, err := getUserBySessionId(session)
+	if err != nil || user == nil || user.Id != existing.Id {
+		t.Fatalf("verified email not linked: %+v %v", user, err)
+	}
+	userId, _ := getIdentityUserId(p.server.URL, "s1")
+	if userId != existing.Id {
+		t.Fatalf("identity linked to %v", userId)
+	}
+
+	// Unverified email of existing user is refused, account is not taken over
+	location, session := oidcSignIn(t, p, oidcClaims{Subject: "s2", Email: "linked@example.com", PreferredUsername: "other"})
+	if location.Query().Get("oidc_error") != "unable_to_link_account" || session != "" {
+		t.Fatalf("unverified email: %v", location)
+	}
+	userId, _ = getIdentityUserId(p.server.URL, "s2")
+	if userId != -1 {
+		t.Fatalf("unverified identity linked to %v", userId)
+	}
+
+	// Verified email of unverified account is refused, account may be someone else's
+	squatter := createTestUser(t, "squatter")
+	_, err = db.Exec("UPDATE users SET verified = 0 WHERE id = ?", squatter.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, session = oidcSignIn(t, p, oidcClaims{Subject: "s4", Email: "squatter@example.com", EmailVerified: true})
+	if location.Query().Get("oidc_error") != "unable_to_link_account" || session != "" {
+		t.Fatalf("unverified account: %v", location)
+	}
+	userId, _ = getIdentityUserId(p.server.URL, "s4")
+	if userId != -1 {
+		t.Fatalf("identity linked to unverified account %v", userId)
+	}
+
+	// Linked identity signs in to the same user even if email changed
+	_, session = oidcSignIn(t, p, oidcClaims{Subject: "s1", Email: "new@example.com", EmailVerified: true})
+	user, _ = getUserBySessionId(session)
+	if user == nil || user.Id != existing.Id {
+		t.Fatalf("linked identity: %+v", user)
+	}
+
+	// New email creates verified user named after provider user name
+	_, session = oidcSignIn(t, p, oidcClaims{Subject: "s3", Email: "fresh@example.com", EmailVerified: true, PreferredUsername: "fresh one"})
+	user, _ = getUserBySessionId(session)
+	if user == nil || user.NickName != "fresh_one" || user.Email != "fresh@example.com" || !user.Verified {
+		t.Fatalf("created user: %+v", user)
+	}
+}
+
+func TestOidcNickNameCollision(t *testing.T) {
+	setupTestDB(t)
+	p := newMockProvider(t)
+	createTestUser(t, "taken")
+	createTestUser(t, "taken2")
+
+	_, session := oidcSignIn(t, p, oidcClaims{Subject: "s1", Email: "someone@example.com", EmailVerified: true, PreferredUsername: "taken"})
+	user, _ := getUserBySessionId(session)
+	if user == nil || user.NickName != "taken3" {
+		t.Fatalf("nick name: %+v", user)
+	}
+}
+
+func TestOidcTwoFactor(t *testing.T) {
+	setupTestDB(t)
+	p := newMockProvider(t)
+	user := createTestUser(t, "guarded")
+	err := saveTOTP(user.Id, rfcSecret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location, session := oidcSignIn(t, p, oidcClaims{Subject: "s1", Email: "guarded@example.com", EmailVerified: true})
+	if session != "" {
+		t.Fatal("session issued without second factor")
+	}
+	challenge := location.Query().Get("totp_challenge")
+	if !verifyToken("signin_totp", challenge) || tokenUserId(challenge) != user.Id {
+		t.Fatalf("no two-factor challenge: %v", location)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Change password of signed in user. Session id is replaced so any other
+// holder of the old session is signed out
+func changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	session_id := r.FormValue("session_id")
+	current_password := r.FormValue("current_password")
+
+	user, err := getUserBySessionId(session_id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if user == nil {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if !compairPasswords(user.Password, current_password) {
+		resp.Error = &Error{Type: INVALID_CURRENT_PASSWORD, Message: "Error: wrong current password"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user.Password = r.FormValue("password")
+	user.Password2 = r.FormValue("password2")
+	resp.Error = validatePassword(user)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = updatePassword(user.Id, encrypt(user.Password))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user.SessionId = generateSessionId()
+	err = updateSessionId(user)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Socket was opened with the old session. Client has to reconnect
+	removeClient(user.Id)
+	broadcastClientsStatus()
+
+	removeUserInfo(user)
+	resp.Payload = user
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Send password reset link to user found by email or nick name.
+// Response is the same whether user exists or not
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, err := getUserByEmailOrNickName(r.FormValue("user_name"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if user == nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	expires := getCurrentMilli() + int64(config.PasswordResetTTL)*60*1000
+	err = insertPasswordReset(user.Id, hashToken(token), expires)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	link := config.BaseUrl + "/?" + url.Values{"reset_token": {token}}.Encode()
+	body := fmt.Sprintf("Hi %v,\n\nTo choose a new password open the link below:\n\n%v\n\nThe link can be used once and expires in %v minutes.\nIf you did not ask to reset your password, ignore this email.\n", user.NickName, link, config.PasswordResetTTL)
+	err = mailer.Send(user.Email, "Password reset", body)
+	if err != nil {
+		errorHandler(err)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Set new password using token from reset email. User is signed out everywhere
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user := User{
+		Password:  r.FormValue("password"),
+		Password2: r.FormValue("password2"),
+	}
+	resp.Error = validatePassword(&user)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId, err := usePasswordReset(hashToken(r.FormValue("token")))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if userId == -1 {
+		resp.Error = &Error{Type: INVALID_TOKEN, Message: "Error: reset link is invalid or expired"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = updatePassword(userId, encrypt(user.Password))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user.Id = userId
+	user.SessionId = ""
+	err = updateSessionId(&user)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	removeClient(userId)
+	broadcastClientsStatus()
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits of poll in new post
+const POLL_MIN_OPTIONS = 2
+const POLL_MAX_OPTIONS = 10
+const POLL_QUESTION_LENGTH = 300
+const POLL_OPTION_LENGTH = 200
+
+// Poll as sent with new post in form field poll, e.g.
+//
+//	{"question": "Lunch?", "options": ["Pizza", "Soup"], "multiple": false, "anonymous": true, "closes_at": 1700000000000}
+type pollForm struct {
+	Question  string   `json:"question"`
+	Options   []string `json:"options"`
+	Multiple  bool     `json:"multiple"`
+	Anonymous bool     `json:"anonymous"`
+	ClosesAt  int64    `json:"closes_at"`
+}
+
+// Optional poll of new post. Poll has to stay open for some time after
+// post is published, which is publishAt for scheduled posts
+func parsePoll(r *http.Request, publishAt int64) (*Poll, *Error) {
+	if r.FormValue("poll") == "" {
+		return nil, nil
+	}
+	form := pollForm{}
+	err := json.Unmarshal([]byte(r.FormValue("poll")), &form)
+	if err != nil {
+		return nil, &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: invalid poll: %v", err)}
+	}
+
+	poll := Poll{
+		Question:  strings.TrimSpace(form.Question),
+		Multiple:  form.Multiple,
+		Anonymous: form.Anonymous,
+		ClosesAt:  form.ClosesAt,
+		Options:   []PollOption{},
+	}
+	if len(poll.Question) > POLL_QUESTION_LENGTH {
+		return nil, &Error{Type: INVALID_INPUT, Message: "Error: poll question is too long"}
+	}
+	texts := []string{}
+	for _, text := range form.Options {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return nil, &Error{Type: INVALID_INPUT, Message: "Error: poll option cannot be empty"}
+		}
+		if len(text) > POLL_OPTION_LENGTH {
+			return nil, &Error{Type: INVALID_INPUT, Message: "Error: poll option is too long"}
+		}
+		if containsString(texts, text) {
+			return nil, &Error{Type: INVALID_INPUT, Message: "Error: poll options should be different"}
+		}
+		texts = append(texts, text)
+		poll.Options = append(poll.Options, PollOption{Text: text})
+	}
+	if len(poll.Options) < POLL_MIN_OPTIONS || len(poll.Options) > POLL_MAX_OPTIONS {
+		return nil, &Error{Type: INVALID_INPUT, Message: fmt.Sprintf("Error: poll should have %v to %v options", POLL_MIN_OPTIONS, POLL_MAX_OPTIONS)}
+	}
+
+	opens := publishAt
+	if opens == 0 {
+		opens = getCurrentMilli()
+	}
+	if poll.ClosesAt < 0 || (poll.ClosesAt > 0 && poll.ClosesAt <= opens) {
+		return nil, &Error{Type: INVALID_INPUT, Message: "Error: poll should close after post is published"}
+	}
+	return &poll, nil
+}
+
+// Fill in options userId has voted for
+func setPollVoted(poll *Poll, postId int, userId int) error {
+	if poll == nil {
+		return nil
+	}
+	var err error
+	poll.Voted, err = getPollVotes(postId, userId)
+	return err
+}
+
+// Vote in poll of post_id for options in option_ids, JSON array of option ids.
+// Earlier vote of user is replaced. Poll of single choice takes one option
+func voteHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	optionIds := []int{}
+	err := json.Unmarshal([]byte(r.FormValue("option_ids")), &optionIds)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: "Error: option_ids should be JSON array of option ids"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if len(optionIds) == 0 {
+		resp.Error = &Error{Type: MISSING_PARAM, Message: "Error: choose at least one option"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	changePollVote(w, r, user, optionIds)
+}
+
+// Take back vote in poll of post_id
+func unvoteHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	changePollVote(w, r, user, []int{})
+}
+
+// Check and store vote, send new results to user and to everybody viewing post
+func changePollVote(w http.ResponseWriter, r *http.Request, user *User, optionIds []int) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	postId, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Error = checkCanWrite(user, config.UnverifiedCanComment)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	post, err := getPost(postId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if post.Id == 0 || post.Poll == nil {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: poll not found"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if post.Locked {
+		resp.Error = &Error{Type: POST_LOCKED, Message: "Error: post is locked, voting is not allowed"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if post.Poll.Closed {
+		resp.Error = &Error{Type: POLL_CLOSED, Message: "Error: poll is closed"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !post.Poll.Multiple && len(optionIds) > 1 {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: only one option can be chosen"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	chosen := map[int]bool{}
+	for _, id := range optionIds {
+		found := false
+		for _, option := range post.Poll.Options {
+			found = found || option.Id == id
+		}
+		if !found || chosen[id] {
+			resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: invalid poll option"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		chosen[id] = true
+	}
+
+	err = setPollVotes(postId, user.Id, optionIds)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	poll, err := getPoll(postId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	publishFeedEvent(pollUpdatedEvent(post, poll))
+
+	own := *poll
+	own.Voted = optionIds
+	resp.Payload = own
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GET  - view own profile or profile of user_id
+// POST - update own profile and privacy settings
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method == "GET" {
+		keys, ok := r.URL.Query()["session_id"]
+		if !ok || len(keys[0]) < 1 {
+			resp.Error = &Error{Type: MISSING_PARAM, Message: "Error: missing request parameter: session_id"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		session_id := keys[0]
+
+		user, err := getUserBySessionId(session_id)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if user == nil {
+			resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		profileUser := user
+		keys, ok = r.URL.Query()["user_id"]
+		if ok && len(keys[0]) > 0 {
+			userId, err := strconv.Atoi(keys[0])
+			if err != nil {
+				resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			profileUser, err = getUserById(userId)
+			if err != nil {
+				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			if profileUser == nil {
+				resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+		}
+
+		profile, e := buildProfile(profileUser, profileUser.Id == user.Id)
+		if e != nil {
+			resp.Error = e
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		// Presence of blocker is hidden from blocked user
+		if profileUser.Id != user.Id {
+			blocked, err := isBlocked(profileUser.Id, user.Id)
+			if err != nil {
+				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			if blocked {
+				profile.User.OnLine = false
+			}
+			profile.Followed, err = isFollowing(user.Id, profileUser.Id)
+			if err != nil {
+				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+		}
+		resp.Payload = profile
+
+	} else if r.Method == "POST" {
+		session_id := r.FormValue("session_id")
+
+		user, err := getUserBySessionId(session_id)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if user == nil {
+			resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		oldEmail := user.Email
+		user.FirstName = strings.TrimSpace(r.FormValue("first_name"))
+		user.LastName = strings.TrimSpace(r.FormValue("last_name"))
+		user.NickName = strings.TrimSpace(r.FormValue("nick_name"))
+		user.Email = strings.TrimSpace(r.FormValue("email"))
+		user.Gender = strings.TrimSpace(r.FormValue("gender"))
+
+		age_str := strings.TrimSpace(r.FormValue("age"))
+		resp.Error = validateUserInfo(user, age_str)
+		if resp.Error != nil {
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		err = updateUser(user)
+		if err != nil {
+			resp.Error = userSaveError(err)
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		// New email has to be confirmed again
+		user.Email = strings.ToLower(user.Email)
+		if user.Email != oldEmail {
+			user.Verified = false
+			err = setVerified(user.Id, false)
+			if err != nil {
+				resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+				json.NewEncoder(w).Encode(resp)
+				return
+			}
+			_, err = updateVerificationSent(user.Id, 0)
+			if err == nil {
+				err = sendVerificationEmail(user)
+			}
+			if err != nil {
+				errorHandler(err)
+			}
+		}
+
+		// Privacy settings not sent in request are kept
+		privacy, err := getPrivacy(user.Id)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		setFlagFromForm(r, "show_first_name", &privacy.FirstName)
+		setFlagFromForm(r, "show_last_name", &privacy.LastName)
+		setFlagFromForm(r, "show_age", &privacy.Age)
+		setFlagFromForm(r, "show_gender", &privacy.Gender)
+		setFlagFromForm(r, "show_email", &privacy.Email)
+		setFlagFromForm(r, "contacts_only", &privacy.ContactsOnly)
+		err = savePrivacy(user.Id, privacy)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		//Nick name might have changed
+		if client, ok := getClient(user.Id); ok {
+			client.setNickName(user.NickName)
+		}
+		broadcastClientsStatus()
+
+		profile, e := buildProfile(user, true)
+		if e != nil {
+			resp.Error = e
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		resp.Payload = profile
+
+	} else {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Set flag to whether field is "true", if field was sent
+func setFlagFromForm(r *http.Request, field string, flag *bool) {
+	if _, ok := r.Form[field]; ok {
+		*flag = r.FormValue(field) == "true"
+	}
+}
+
+// Build profile of user. Owner sees all own fields and privacy settings,
+// other users see only fields allowed by privacy settings
+func buildProfile(user *User, own bool) (*Profile, *Error) {
+	privacy, err := getPrivacy(user.Id)
+	if err != nil {
+		return nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+
+	profile := Profile{User: user}
+	err = getProfileStats(&profile)
+	if err != nil {
+		return nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+
+	user.Password = ""
+	user.Password2 = ""
+	if own {
+		profile.Privacy = privacy
+	} else {
+		user.SessionId = ""
+		applyPrivacy(user, privacy)
+	}
+	setOnLineStatus(user)
+
+	return &profile, nil
+}
+
+func applyPrivacy(user *User, privacy *Privacy) {
+	if !privacy.FirstName {
+		user.FirstName = ""
+	}
+	if !privacy.LastName {
+		user.LastName = ""
+	}
+	if !privacy.Age {
+		user.Age = 0
+	}
+	if !privacy.Gender {
+		user.Gender = ""
+	}
+	if !privacy.Email {
+		user.Email = ""
+	}
+}
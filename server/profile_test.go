@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func postProfile(t *testing.T, user *User, fields url.Values) *Error {
+	t.Helper()
+	form := url.Values{
+		"session_id": {user.SessionId},
+		"first_name": {user.FirstName},
+		"last_name":  {user.LastName},
+		"nick_name":  {user.NickName},
+		"email":      {user.Email},
+		"gender":     {user.Gender},
+		"age":        {"30"},
+	}
+	for field, values := range fields {
+		form[field] = values
+	}
+	r := httptest.NewRequest("POST", "/profile", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	profileHandler(w, r)
+
+	resp := Response{}
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.Error
+}
+
+func TestProfileUpdateKeepsPrivacy(t *testing.T) {
+	setupTestDB(t)
+	user := createTestSession(t, "private")
+
+	e := postProfile(t, user, url.Values{"show_email": {"true"}, "contacts_only": {"true"}})
+	if e != nil {
+		t.Fatal(e.Message)
+	}
+	// Settings not sent stay as they were
+	e = postProfile(t, user, url.Values{"show_age": {"true"}})
+	if e != nil {
+		t.Fatal(e.Message)
+	}
+	privacy, err := getPrivacy(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *privacy != (Privacy{Email: true, Age: true, ContactsOnly: true}) {
+		t.Errorf("privacy: %+v", privacy)
+	}
+
+	e = postProfile(t, user, url.Values{"contacts_only": {"false"}})
+	if e != nil {
+		t.Fatal(e.Message)
+	}
+	privacy, _ = getPrivacy(user.Id)
+	if *privacy != (Privacy{Email: true, Age: true}) {
+		t.Errorf("privacy after turning contacts only off: %+v", privacy)
+	}
+}
+
+func TestProfileStatsSkipHiddenPosts(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "poster")
+	createTestPost(t, user, "shown")
+	hidden := createTestPost(t, user, "hidden")
+	_, err := db.Exec("UPDATE posts SET hidden = 1 WHERE id = ?", hidden.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile := Profile{User: user}
+	err = getProfileStats(&profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.NumberOfPosts != 1 {
+		t.Errorf("number of posts %v", profile.NumberOfPosts)
+	}
+}
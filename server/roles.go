@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Roles
+const ROLE_ADMIN = "admin"
+const ROLE_MODERATOR = "moderator"
+const ROLE_MEMBER = "member"
+
+// Permissions
+const PERMISSION_REMOVE_CONTENT = "remove_content"
+const PERMISSION_MANAGE_CATEGORIES = "manage_categories"
+const PERMISSION_MANAGE_USERS = "manage_users"
+const PERMISSION_MODERATE_REPORTS = "moderate_reports"
+const PERMISSION_SANCTION_USERS = "sanction_users"
+const PERMISSION_MANAGE_POSTS = "manage_posts"
+
+var rolePermissions = map[string][]string{
+	ROLE_ADMIN:     {PERMISSION_REMOVE_CONTENT, PERMISSION_MANAGE_CATEGORIES, PERMISSION_MANAGE_USERS, PERMISSION_MODERATE_REPORTS, PERMISSION_SANCTION_USERS, PERMISSION_MANAGE_POSTS},
+	ROLE_MODERATOR: {PERMISSION_REMOVE_CONTENT, PERMISSION_MODERATE_REPORTS, PERMISSION_SANCTION_USERS, PERMISSION_MANAGE_POSTS},
+	ROLE_MEMBER:    {},
+}
+
+// Handler that receives signed in user checked by middleware
+type userHandler func(w http.ResponseWriter, r *http.Request, user *User)
+
+func isValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func hasPermission(user *User, permission string) bool {
+	return containsString(rolePermissions[user.Role], permission)
+}
+
+// Middleware. Loads user by session_id and calls handler only if user is signed in
+func requireUser(handler userHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := Response{Payload: nil, Error: nil}
+
+		user, err := getUserBySessionId(r.FormValue("session_id"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if user == nil {
+			resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		handler(w, r, user)
+	}
+}
+
+// Middleware. Calls handler only if role of signed in user has permission
+func requirePermission(permission string, handler userHandler) http.HandlerFunc {
+	return requireUser(func(w http.ResponseWriter, r *http.Request, user *User) {
+		if !hasPermission(user, permission) {
+			resp := Response{Payload: nil, Error: &Error{Type: FORBIDDEN, Message: "Error: you are not allowed to do this"}}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		handler(w, r, user)
+	})
+}
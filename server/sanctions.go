@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Banned users cannot sign in, muted users can read but not post, comment or message
+const SANCTION_BAN = "ban"
+const SANCTION_MUTE = "mute"
+
+func sanctionMessage(sanction *Sanction) string {
+	action := "banned"
+	if sanction.Type == SANCTION_MUTE {
+		action = "muted"
+	}
+	until := "permanently"
+	if sanction.Expires != 0 {
+		until = "until " + formatMilli(int(sanction.Expires))
+	}
+	return fmt.Sprintf("Error: you are %v %v. Reason: %v", action, until, sanction.Reason)
+}
+
+func checkNotBanned(user *User) *Error {
+	ban, err := getActiveSanction(user.Id, SANCTION_BAN)
+	if err != nil {
+		return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if ban != nil {
+		return &Error{Type: USER_BANNED, Message: sanctionMessage(ban)}
+	}
+	return nil
+}
+
+// Check done on every write path: user is not muted and, unless allowed, has verified email
+func checkCanWrite(user *User, allowedUnverified bool) *Error {
+	e := checkVerified(user, allowedUnverified)
+	if e != nil {
+		return e
+	}
+	mute, err := getActiveSanction(user.Id, SANCTION_MUTE)
+	if err != nil {
+		return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if mute != nil {
+		return &Error{Type: USER_MUTED, Message: sanctionMessage(mute)}
+	}
+	return nil
+}
+
+// Address of client. X-Forwarded-For is used only if server is behind trusted proxy
+func clientIp(r *http.Request) net.IP {
+	if config.TrustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		ip := net.ParseIP(strings.TrimSpace(forwarded[0]))
+		if ip != nil {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// Checked before sign in and sign up
+func checkIpAllowed(r *http.Request) *Error {
+	ip := clientIp(r)
+	if ip == nil {
+		return nil
+	}
+	bans, err := getActiveIpBans()
+	if err != nil {
+		return &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	for _, ban := range bans {
+		_, network, err := net.ParseCIDR(ban.Cidr)
+		if err != nil {
+			continue
+		}
+		if network.Contains(ip) {
+			return &Error{Type: IP_BANNED, Message: "Error: access from your network is blocked"}
+		}
+	}
+	return nil
+}
+
+// Single address is turned into /32 or /128 network
+func parseCidr(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if !strings.Contains(value, "/") {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", false
+		}
+		if ip.To4() != nil {
+			return ip.String() + "/32", true
+		}
+		return ip.String() + "/128", true
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return "", false
+	}
+	return network.String(), true
+}
+
+// Expiry time from duration in minutes. 0 means permanent
+func parseExpires(duration string) (int64, bool) {
+	if strings.TrimSpace(duration) == "" {
+		return 0, true
+	}
+	minutes, err := strconv.Atoi(duration)
+	if err != nil || minutes < 0 {
+		return 0, false
+	}
+	if minutes == 0 {
+		return 0, true
+	}
+	return getCurrentMilli() + int64(minutes)*60*1000, true
+}
+
+// Ban or mute user. Banned user is signed out and disconnected at once
+func sanctionHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	sanction := Sanction{
+		Type:        r.FormValue("type"),
+		Reason:      strings.TrimSpace(r.FormValue("reason")),
+		ModeratorId: user.Id,
+	}
+	if sanction.Type != SANCTION_BAN && sanction.Type != SANCTION_MUTE {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: type should be ban or mute"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if len(sanction.Reason) == 0 || len(sanction.Reason) > 500 {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: reason should be between 1 and 500 characters long"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	expires, ok := parseExpires(r.FormValue("duration"))
+	if !ok {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: duration should be number of minutes"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	sanction.Expires = expires
+
+	userId, err := strconv.Atoi(r.FormValue("user_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	target, err := getUserById(userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if target == nil {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !canSanction(user, target) {
+		resp.Error = &Error{Type: FORBIDDEN, Message: "Error: you are not allowed to do this"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	sanction.UserId = target.Id
+
+	err = insertSanction(&sanction)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if sanction.Type == SANCTION_BAN {
+		err = resetUserSession(target.Id)
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		evictClient(target.Id, sanctionMessage(&sanction))
+		broadcastClientsStatus()
+	} else {
+		b, err := json.Marshal(SanctionWrapper{sanction})
+		if err == nil {
+			notifyClient(target.Id, b)
+		}
+	}
+
+	resp.Payload = sanction
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Moderators can sanction members only, admins anyone but themselves
+func canSanction(user *User, target *User) bool {
+	return target.Id != user.Id && (target.Role == ROLE_MEMBER || user.Role == ROLE_ADMIN)
+}
+
+// Revoke sanction_id. Allowed to users who could have issued it
+func revokeSanctionHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("sanction_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	sanction, err := getSanction(id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if sanction == nil {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: no such sanction"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	target, err := getUserById(sanction.UserId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	// Same rules as for sanctioning, so nobody lifts own sanction
+	if target != nil && !canSanction(user, target) {
+		resp.Error = &Error{Type: FORBIDDEN, Message: "Error: you are not allowed to do this"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = revokeSanction(id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Active sanctions of user_id, or of everybody if user_id is missing
+func sanctionsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	userId := -1
+	if r.FormValue("user_id") != "" {
+		id, err := strconv.Atoi(r.FormValue("user_id"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		userId = id
+	}
+
+	sanctions, err := getActiveSanctions(userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = sanctions
+	json.NewEncoder(w).Encode(resp)
+}
+
+func banIpHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	cidr, ok := parseCidr(r.FormValue("cidr"))
+	if !ok {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: invalid address or network"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	expires, ok := parseExpires(r.FormValue("duration"))
+	if !ok {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: duration should be number of minutes"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	ban := IpBan{
+		Cidr:        cidr,
+		Reason:      strings.TrimSpace(r.FormValue("reason")),
+		Expires:     expires,
+		ModeratorId: user.Id,
+	}
+	err := insertIpBan(&ban)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = ban
+	json.NewEncoder(w).Encode(resp)
+}
+
+func unbanIpHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	id, err := strconv.Atoi(r.FormValue("ban_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = deleteIpBan(id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func ipBansHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	bans, err := getActiveIpBans()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = bans
+	json.NewEncoder(w).Encode(resp)
+}
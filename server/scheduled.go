@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Posts and messages can be scheduled at most this many milliseconds ahead
+const SCHEDULE_MAX_AHEAD = 365 * 24 * 60 * 60 * 1000
+
+// Scheduler checks for due content at least this often, also when nothing is scheduled
+const SCHEDULER_INTERVAL = time.Minute
+
+// Scheduler waits at least this long between checks, so content that stays due
+// because of an error is not retried in a busy loop
+const SCHEDULER_MIN_INTERVAL = time.Second
+
+// Wakes scheduler up when content is scheduled or rescheduled
+var schedulerWake = make(chan bool, 1)
+
+func wakeScheduler() {
+	select {
+	case schedulerWake <- true:
+	default:
+	}
+}
+
+// Optional publish_at of request, in milliseconds since epoch. 0 if it is not given
+func parsePublishAt(r *http.Request) (int64, *Error) {
+	if r.FormValue("publish_at") == "" {
+		return 0, nil
+	}
+	publishAt, err := strconv.ParseInt(r.FormValue("publish_at"), 10, 64)
+	if err != nil {
+		return 0, &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	now := getCurrentMilli()
+	if publishAt <= now {
+		return 0, &Error{Type: INVALID_INPUT, Message: "Error: publish time should be in the future"}
+	}
+	if publishAt > now+SCHEDULE_MAX_AHEAD {
+		return 0, &Error{Type: INVALID_INPUT, Message: "Error: publish time is too far in the future"}
+	}
+	return publishAt, nil
+}
+
+// Publish scheduled posts and send scheduled messages when they are due.
+// Schedule is kept in database, so content due while server was down
+// is published once it starts. Meant to run in its own goroutine
+func runScheduler() {
+	for {
+		publishDueContent()
+
+		wait := SCHEDULER_INTERVAL
+		next, err := getNextPublishAt()
+		if err != nil {
+			errorHandler(err)
+		} else if next > 0 && time.Duration(next-getCurrentMilli())*time.Millisecond < wait {
+			wait = time.Duration(next-getCurrentMilli()) * time.Millisecond
+		}
+		if wait < SCHEDULER_MIN_INTERVAL {
+			wait = SCHEDULER_MIN_INTERVAL
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-schedulerWake:
+			timer.Stop()
+		}
+	}
+}
+
+func publishDueContent() {
+	now := getCurrentMilli()
+	postIds, err := getDuePosts(now)
+	if err != nil {
+		errorHandler(err)
+	}
+	for _, id := range postIds {
+		err = publishScheduledPost(id, now)
+		if err != nil {
+			errorHandler(err)
+		}
+	}
+	messageIds, err := getDueMessages(now)
+	if err != nil {
+		errorHandler(err)
+	}
+	for _, id := range messageIds {
+		err = sendScheduledMessage(id, now)
+		if err != nil {
+			errorHandler(err)
+		}
+	}
+}
+
+// Post of author who can no longer post, or no longer exists, is deleted instead
+func publishScheduledPost(postId int, now int64) error {
+	userId, err := getScheduledPostUserId(postId)
+	if err != nil || userId == -1 {
+		return err
+	}
+	user, err := getUserById(userId)
+	if err != nil {
+		return err
+	}
+	e := checkCanPublish(user, config.UnverifiedCanPost)
+	if e != nil {
+		fmt.Println("Scheduled post", postId, "dropped:", e.Message)
+		return deletePost(postId)
+	}
+
+	published, err := markPostPublished(postId, now)
+	if err != nil || !published {
+		return err
+	}
+	post, err := getPost(postId)
+	if err != nil || post.Id == 0 {
+		return err
+	}
+	publishPost(user, post)
+	return nil
+}
+
+// Message is a request or not depending on recipient's settings at time of sending.
+// Message that can no longer be sent is deleted instead
+func sendScheduledMessage(messageId int, now int64) error {
+	m, err := getMessage(messageId)
+	if err != nil {
+		return err
+	}
+	// Sender no longer exists
+	if m == nil {
+		return dropScheduledMessage(messageId)
+	}
+	user, err := getUserById(m.FromId)
+	if err != nil {
+		return err
+	}
+	pending := false
+	e := checkCanPublish(user, config.UnverifiedCanMessage)
+	if e == nil {
+		pending, e = checkCanMessage(user, m.ToId)
+	}
+	if e != nil {
+		fmt.Println("Scheduled message", messageId, "dropped:", e.Message)
+		return dropScheduledMessage(messageId)
+	}
+
+	sent, err := markMessageSent(messageId, now, pending)
+	if err != nil || !sent {
+		return err
+	}
+	m.Date = now
+	m.Pending = pending
+	m.PublishAt = 0
+	m.Attachments, err = getAttachments(CONTENT_MESSAGE, messageId)
+	if err != nil {
+		return err
+	}
+	return sendMessage(m)
+}
+
+// Author may have been banned or muted after scheduling
+func checkCanPublish(user *User, allowedUnverified bool) *Error {
+	if user == nil {
+		return &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+	}
+	e := checkNotBanned(user)
+	if e != nil {
+		return e
+	}
+	return checkCanWrite(user, allowedUnverified)
+}
+
+// Scheduled posts and messages of signed in user
+func scheduledHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	posts, err := getScheduledPosts(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	messages, err := getScheduledMessages(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = ScheduledContent{Posts: posts, Messages: messages}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Content type and id of scheduled content in request
+func scheduledContentFromForm(r *http.Request) (string, int, *Error) {
+	contentType := r.FormValue("content_type")
+	if contentType != CONTENT_POST && contentType != CONTENT_MESSAGE {
+		return "", 0, &Error{Type: INVALID_INPUT, Message: "Error: content_type should be post or message"}
+	}
+	contentId, err := strconv.Atoi(r.FormValue("content_id"))
+	if err != nil {
+		return "", 0, &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	return contentType, contentId, nil
+}
+
+// Move scheduled post or message of signed in user to new publish_at
+func rescheduleHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	contentType, contentId, e := scheduledContentFromForm(r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	publishAt, e := parsePublishAt(r)
+	if e == nil && publishAt == 0 {
+		e = &Error{Type: MISSING_PARAM, Message: "Error: missing request parameter: publish_at"}
+	}
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var found bool
+	var err error
+	if contentType == CONTENT_POST {
+		found, err = reschedulePost(user.Id, contentId, publishAt)
+	} else {
+		found, err = rescheduleMessage(user.Id, contentId, publishAt)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: no such scheduled content, it may have been published already"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	wakeScheduler()
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Delete scheduled post or message of signed in user before it is published
+func cancelScheduledHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	contentType, contentId, e := scheduledContentFromForm(r)
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	var found bool
+	var err error
+	if contentType == CONTENT_POST {
+		found, err = deleteScheduledPost(user.Id, contentId)
+	} else {
+		found, err = deleteScheduledMessage(user.Id, contentId)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !found {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: no such scheduled content, it may have been published already"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPublishDueContent(t *testing.T) {
+	setupTestDB(t)
+	author := createTestUser(t, "planner")
+	gone := createTestUser(t, "gone")
+	reader := createTestUser(t, "reader")
+	due := getCurrentMilli() - 1000
+
+	post := &Post{Title: "Title", Content: "on time", Categories: []string{}, PublishAt: due}
+	orphan := &Post{Title: "Title", Content: "author deleted", Categories: []string{}, PublishAt: due}
+	err := insertPost(author, post)
+	if err == nil {
+		err = insertPost(gone, orphan)
+	}
+	message := &Message{FromId: gone.Id, ToId: reader.Id, Content: "sender deleted", PublishAt: due}
+	if err == nil {
+		err = insertMessage(message)
+	}
+	if err == nil {
+		_, err = db.Exec("DELETE FROM users WHERE id = ?", gone.Id)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishDueContent()
+
+	published, err := getPost(post.Id)
+	if err != nil || published.Id != post.Id {
+		t.Fatalf("post not published: %+v %v", published, err)
+	}
+	// Content that cannot be published is not left due
+	var count int
+	err = db.QueryRow("SELECT (SELECT COUNT(*) FROM posts WHERE id = ?) + (SELECT COUNT(*) FROM messages WHERE id = ?)", orphan.Id, message.Id).Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("content of deleted users left: %v", count)
+	}
+	next, err := getNextPublishAt()
+	if err != nil || next != 0 {
+		t.Errorf("next publish at %v, %v", next, err)
+	}
+}
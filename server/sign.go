@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var secretKey []byte
+
+// Key used to sign tokens. Taken from SECRET_KEY or generated once and kept in settings table
+func getSecretKey() ([]byte, error) {
+	if secretKey != nil {
+		return secretKey, nil
+	}
+	if config.SecretKey != "" {
+		secretKey = []byte(config.SecretKey)
+		return secretKey, nil
+	}
+	key, err := getSetting("secret_key")
+	if err != nil {
+		return nil, err
+	}
+	if key == "" {
+		key, err = generateToken()
+		if err != nil {
+			return nil, err
+		}
+		err = saveSetting("secret_key", key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	secretKey = []byte(key)
+	return secretKey, nil
+}
+
+// Create token "<user id>.<expires>.<signature>". Purpose is signed but not included
+// in token, so a token issued for one purpose is rejected for another
+func signToken(purpose string, userId int, expires int64) (string, error) {
+	key, err := getSecretKey()
+	if err != nil {
+		return "", err
+	}
+	payload := fmt.Sprintf("%v.%v", userId, expires)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(purpose + "|" + payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// Returns user id from token without checking it, or -1 if token is malformed
+func tokenUserId(token string) int {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return -1
+	}
+	userId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return -1
+	}
+	return userId
+}
+
+// Check signature and expiry of token created by signToken
+func verifyToken(purpose string, token string) bool {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	userId, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || expires < getCurrentMilli() {
+		return false
+	}
+	expected, err := signToken(purpose, userId, expires)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(expected), []byte(token))
+}
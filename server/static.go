@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Web client is in parent directory of server. Only its files are served,
+// never database, outbox, uploads or anything else found next to them
+const CLIENT_DIR = "../"
+const CLIENT_IMAGES = "/images/"
+
+var clientFiles = []string{"/", "/index.html", "/app.js", "/styles.css"}
+
+// Files of web client, directory listings are refused
+func clientHandler() http.Handler {
+	fileServer := http.FileServer(http.Dir(CLIENT_DIR))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if containsString(clientFiles, name) ||
+			(strings.HasPrefix(name, CLIENT_IMAGES) && !strings.HasSuffix(r.URL.Path, "/")) {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// True if files in dir could be served as part of web client
+func isServedDir(dir string) bool {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return true
+	}
+	images, err := filepath.Abs(filepath.Join(CLIENT_DIR, CLIENT_IMAGES))
+	if err != nil {
+		return true
+	}
+	return abs == images || strings.HasPrefix(abs, images+string(filepath.Separator))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Subscribe to comments of post_id. Notifications are pushed in real time
+// unless push is false. Subscribing again changes push
+func subscribeHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	postId, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	push := true
+	if r.FormValue("push") != "" {
+		push, err = strconv.ParseBool(r.FormValue("push"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	post, err := getPost(postId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if post.Id == 0 {
+		resp.Error = &Error{Type: CONTENT_NOT_FOUND, Message: "Error: post not found"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = saveSubscription(user.Id, post.Id, push)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	postId, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = deleteSubscription(user.Id, postId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Subscriptions of signed in user with subscribed posts, page by page
+func subscriptionsHandler(w http.ResponseWriter, r *http.Request, user *User) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "GET" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	page := 1
+	if r.FormValue("page") != "" {
+		p, err := strconv.Atoi(r.FormValue("page"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if p > 0 {
+			page = p
+		}
+	}
+
+	subscriptions, err := getSubscriptions(user.Id, page)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	for i := range subscriptions {
+		s := &subscriptions[i]
+		s.Post, err = getPost(s.PostId)
+		if err == nil {
+			s.Post.Subscribed = true
+			s.Post.Bookmarked, err = isBookmarked(user.Id, CONTENT_POST, s.PostId)
+		}
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	resp.Payload = subscriptions
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time-based one-time passwords (RFC 6238) with the settings every
+// authenticator app supports: SHA1, 6 digits, 30 seconds
+const totpIssuer = "Green Chat Forum"
+const totpPeriod = 30
+const totpDigits = 6
+
+// Codes from this many periods before and after current one are accepted
+const totpSkew = 1
+
+const recoveryCodesCount = 10
+
+// Wrong codes allowed before two-factor sign in is blocked for totpLockMinutes
+const totpMaxFailures = 5
+const totpLockMinutes = 15
+
+// Clock used for codes and lockouts. Replaced in tests
+var timeNow = time.Now
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Wrong codes of user and time of last one in seconds
+type totpFailure struct {
+	count int
+	last  int64
+}
+
+var totpFailures = make(map[int]*totpFailure)
+var totpFailuresMutex sync.Mutex
+
+func generateTOTPSecret() (string, error) {
+	bytes := make([]byte, 20)
+	_, err := rand.Read(bytes)
+	if err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(bytes), nil
+}
+
+// Code for given time step (number of periods since Unix epoch)
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// Returns time step matching code, or -1 if code is wrong
+func checkTOTPCode(secret string, code string, t time.Time) int64 {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return -1
+	}
+	current := t.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return -1
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step
+		}
+	}
+	return -1
+}
+
+// Provisioning URI to be shown as QR code by the client
+func totpURI(secret string, nickName string) string {
+	label := url.PathEscape(totpIssuer + ":" + nickName)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + label + "?" + strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+// Generate recovery codes, store their hashes and return codes to be shown once
+func newRecoveryCodes(userId int) ([]string, error) {
+	codes := []string{}
+	hashes := []string{}
+	for i := 0; i < recoveryCodesCount; i++ {
+		bytes := make([]byte, 5)
+		_, err := rand.Read(bytes)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(base32NoPadding.EncodeToString(bytes))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, encrypt(code))
+	}
+	err := saveRecoveryCodes(userId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Token proving that password was already checked. Valid for 5 minutes, for one attempt
+func newTOTPChallenge(userId int) (string, error) {
+	expires := getCurrentMilli() + 5*60*1000
+	challenge, err := signToken("signin_totp", userId, expires)
+	if err != nil {
+		return "", err
+	}
+	err = insertTOTPChallenge(userId, hashToken(challenge), expires)
+	if err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// Check authenticator code, or recovery code if code is empty.
+// Each code is accepted only once
+func checkSecondFactor(userId int, totp *TOTP, code string, recoveryCode string) (bool, error) {
+	if code != "" {
+		step := checkTOTPCode(totp.Secret, code, timeNow())
+		if step == -1 {
+			return false, nil
+		}
+		return useTOTPStep(userId, step)
+	}
+	if recoveryCode != "" {
+		return useRecoveryCode(userId, strings.ToLower(strings.TrimSpace(recoveryCode)))
+	}
+	return false, nil
+}
+
+func totpLocked(userId int) bool {
+	totpFailuresMutex.Lock()
+	defer totpFailuresMutex.Unlock()
+	failure, ok := totpFailures[userId]
+	if !ok {
+		return false
+	}
+	if timeNow().Unix()-failure.last > totpLockMinutes*60 {
+		delete(totpFailures, userId)
+		return false
+	}
+	return failure.count >= totpMaxFailures
+}
+
+func recordTOTPResult(userId int, ok bool) {
+	totpFailuresMutex.Lock()
+	defer totpFailuresMutex.Unlock()
+	if ok {
+		delete(totpFailures, userId)
+		return
+	}
+	failure, exists := totpFailures[userId]
+	if !exists {
+		failure = &totpFailure{}
+		totpFailures[userId] = failure
+	}
+	failure.count++
+	failure.last = timeNow().Unix()
+}
+
+// Second step of sign in. Issues session once code from authenticator app
+// or a recovery code is checked
+func signinTOTPHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// IP may have been banned after password was checked
+	resp.Error = checkIpAllowed(r)
+	if resp.Error != nil {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	challenge := r.FormValue("challenge")
+	if !verifyToken("signin_totp", challenge) {
+		resp.Error = &Error{Type: INVALID_TOKEN, Message: "Error: sign in again"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	userId := tokenUserId(challenge)
+
+	// Challenge is good for one attempt, wrong code means signing in again
+	used, err := useTOTPChallenge(hashToken(challenge))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !used {
+		resp.Error = &Error{Type: INVALID_TOKEN, Message: "Error: sign in again"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if totpLocked(userId) {
+		resp.Error = &Error{Type: TOO_MANY_REQUESTS, Message: "Error: too many wrong codes, try again later"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, err := getUserById(userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if user == nil {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: no such user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	totp, err := getTOTP(userId)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Two-factor authentication was turned off after password was checked
+	if !totp.Enabled {
+		resp.Error = &Error{Type: INVALID_TOKEN, Message: "Error: sign in again"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	ok, err := checkSecondFactor(userId, totp, r.FormValue("code"), r.FormValue("recovery_code"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	recordTOTPResult(userId, ok)
+	if !ok {
+		resp.Error = &Error{Type: INVALID_TWO_FACTOR_CODE, Message: "Error: wrong code"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	data, e := startSession(user)
+	resp.Error = e
+	if e == nil {
+		resp.Payload = data
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Generate new secret. Two-factor authentication is off until secret is confirmed with /totpenable
+func totpSetupHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, totp, e := getTOTPUser(r.FormValue("session_id"))
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if totp.Enabled {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: two-factor authentication is already on"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_PARSING_DATA, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	err = saveTOTP(user.Id, secret, false)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = TwoFactorSetup{Secret: secret, Uri: totpURI(secret, user.NickName)}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Turn two-factor authentication on after checking first code. Returns recovery codes
+func totpEnableHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, totp, e := getTOTPUser(r.FormValue("session_id"))
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if totp.Enabled || totp.Secret == "" {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: start two-factor setup first"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	step := checkTOTPCode(totp.Secret, r.FormValue("code"), timeNow())
+	if step == -1 {
+		resp.Error = &Error{Type: INVALID_TWO_FACTOR_CODE, Message: "Error: wrong code"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err := saveTOTP(user.Id, totp.Secret, true)
+	if err == nil {
+		_, err = useTOTPStep(user.Id, step)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	codes, err := newRecoveryCodes(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = RecoveryCodes{Codes: codes}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Turn two-factor authentication off. Requires password and a code
+func totpDisableHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, totp, e := getTOTPUser(r.FormValue("session_id"))
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if !compairPasswords(user.Password, r.FormValue("password")) {
+		resp.Error = &Error{Type: INVALID_CURRENT_PASSWORD, Message: "Error: wrong password"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if totp.Enabled {
+		ok, err := checkSecondFactor(user.Id, totp, r.FormValue("code"), r.FormValue("recovery_code"))
+		if err != nil {
+			resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		if !ok {
+			resp.Error = &Error{Type: INVALID_TWO_FACTOR_CODE, Message: "Error: wrong code"}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+	}
+
+	err := saveTOTP(user.Id, "", false)
+	if err == nil {
+		err = deleteRecoveryCodes(user.Id)
+	}
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Replace recovery codes with new ones. Requires code from authenticator app
+func totpRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, totp, e := getTOTPUser(r.FormValue("session_id"))
+	if e != nil {
+		resp.Error = e
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !totp.Enabled {
+		resp.Error = &Error{Type: INVALID_INPUT, Message: "Error: two-factor authentication is off"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	ok, err := checkSecondFactor(user.Id, totp, r.FormValue("code"), "")
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !ok {
+		resp.Error = &Error{Type: INVALID_TWO_FACTOR_CODE, Message: "Error: wrong code"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	codes, err := newRecoveryCodes(user.Id)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	resp.Payload = RecoveryCodes{Codes: codes}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Signed in user with two-factor settings
+func getTOTPUser(session_id string) (*User, *TOTP, *Error) {
+	user, err := getUserBySessionId(session_id)
+	if err != nil {
+		return nil, nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	if user == nil {
+		return nil, nil, &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+	}
+	totp, err := getTOTP(user.Id)
+	if err != nil {
+		return nil, nil, &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+	}
+	return user, totp, nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Secret "12345678901234567890" of RFC 6238 appendix B
+var rfcSecret = base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+
+// Fix clock used by TOTP code for duration of test
+func setTestClock(t *testing.T, now time.Time) *time.Time {
+	t.Helper()
+	clock := now
+	previous := timeNow
+	timeNow = func() time.Time { return clock }
+	t.Cleanup(func() { timeNow = previous })
+	return &clock
+}
+
+func resetTOTPFailures(t *testing.T) {
+	totpFailuresMutex.Lock()
+	totpFailures = make(map[int]*totpFailure)
+	totpFailuresMutex.Unlock()
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// SHA1 vectors of RFC 6238, last 6 of the 8 digits
+	vectors := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range vectors {
+		code, err := totpCode(rfcSecret, v.time/totpPeriod)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != v.code {
+			t.Errorf("time %v: got %v, want %v", v.time, code, v.code)
+		}
+		if step := checkTOTPCode(rfcSecret, v.code, time.Unix(v.time, 0)); step != v.time/totpPeriod {
+			t.Errorf("time %v: code not accepted, step %v", v.time, step)
+		}
+	}
+}
+
+func TestTOTPSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+	for offset := int64(-2); offset <= 2; offset++ {
+		code, err := totpCode(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step := checkTOTPCode(rfcSecret, code, now)
+		accepted := offset >= -totpSkew && offset <= totpSkew
+		if accepted && step != current+offset {
+			t.Errorf("offset %v: code rejected", offset)
+		}
+		if !accepted && step != -1 {
+			t.Errorf("offset %v: code accepted", offset)
+		}
+	}
+	if step := checkTOTPCode(rfcSecret, "12345", now); step != -1 {
+		t.Errorf("short code accepted")
+	}
+}
+
+func TestTOTPReplay(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "replay")
+	now := setTestClock(t, time.Unix(1234567890, 0))
+	err := saveTOTP(user.Id, rfcSecret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp, err := getTOTP(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, _ := totpCode(rfcSecret, now.Unix()/totpPeriod)
+	ok, err := checkSecondFactor(user.Id, totp, code, "")
+	if err != nil || !ok {
+		t.Fatalf("first use rejected: %v %v", ok, err)
+	}
+	ok, err = checkSecondFactor(user.Id, totp, code, "")
+	if err != nil || ok {
+		t.Fatalf("replayed code accepted: %v %v", ok, err)
+	}
+
+	// Code of earlier step, still within skew, is rejected once a later one was used
+	earlier, _ := totpCode(rfcSecret, now.Unix()/totpPeriod-1)
+	ok, err = checkSecondFactor(user.Id, totp, earlier, "")
+	if err != nil || ok {
+		t.Fatalf("earlier code accepted: %v %v", ok, err)
+	}
+
+	*now = now.Add(totpPeriod * time.Second)
+	next, _ := totpCode(rfcSecret, now.Unix()/totpPeriod)
+	ok, err = checkSecondFactor(user.Id, totp, next, "")
+	if err != nil || !ok {
+		t.Fatalf("next code rejected: %v %v", ok, err)
+	}
+}
+
+func TestTOTPLockout(t *testing.T) {
+	resetTOTPFailures(t)
+	now := setTestClock(t, time.Unix(1234567890, 0))
+	userId := 42
+
+	for i := 0; i < totpMaxFailures-1; i++ {
+		recordTOTPResult(userId, false)
+	}
+	if totpLocked(userId) {
+		t.Fatal("locked before limit")
+	}
+	recordTOTPResult(userId, false)
+	if !totpLocked(userId) {
+		t.Fatal("not locked after limit")
+	}
+
+	*now = now.Add(totpLockMinutes*time.Minute - time.Second)
+	if !totpLocked(userId) {
+		t.Fatal("lock expired early")
+	}
+	*now = now.Add(2 * time.Second)
+	if totpLocked(userId) {
+		t.Fatal("lock did not expire")
+	}
+
+	// Success clears earlier failures
+	recordTOTPResult(userId, false)
+	recordTOTPResult(userId, true)
+	for i := 0; i < totpMaxFailures-1; i++ {
+		recordTOTPResult(userId, false)
+	}
+	if totpLocked(userId) {
+		t.Fatal("failures before success were counted")
+	}
+}
+
+func TestRecoveryCodesSingleUse(t *testing.T) {
+	setupTestDB(t)
+	user := createTestUser(t, "recovery")
+	err := saveTOTP(user.Id, rfcSecret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp, err := getTOTP(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := newRecoveryCodes(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodesCount {
+		t.Fatalf("got %v codes", len(codes))
+	}
+
+	ok, err := checkSecondFactor(user.Id, totp, "", " "+strings.ToUpper(codes[0])+" ")
+	if err != nil || !ok {
+		t.Fatalf("recovery code rejected: %v %v", ok, err)
+	}
+	ok, err = checkSecondFactor(user.Id, totp, "", codes[0])
+	if err != nil || ok {
+		t.Fatalf("used recovery code accepted: %v %v", ok, err)
+	}
+	ok, err = checkSecondFactor(user.Id, totp, "", "aaaa-aaaa")
+	if err != nil || ok {
+		t.Fatalf("unknown recovery code accepted: %v %v", ok, err)
+	}
+
+	// New codes replace old ones
+	fresh, err := newRecoveryCodes(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, _ = checkSecondFactor(user.Id, totp, "", codes[1])
+	if ok {
+		t.Fatal("replaced recovery code accepted")
+	}
+	ok, _ = checkSecondFactor(user.Id, totp, "", fresh[1])
+	if !ok {
+		t.Fatal("new recovery code rejected")
+	}
+}
+
+func postSigninTOTP(challenge string, code string, remoteAddr string) Response {
+	form := url.Values{"challenge": {challenge}, "code": {code}}
+	r := httptest.NewRequest("POST", "/signintotp", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	signinTOTPHandler(w, r)
+	resp := Response{}
+	json.NewDecoder(w.Body).Decode(&resp)
+	return resp
+}
+
+func newTestChallenge(t *testing.T, user *User) string {
+	t.Helper()
+	challenge, err := newTOTPChallenge(user.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return challenge
+}
+
+func TestSigninTOTPHandler(t *testing.T) {
+	setupTestDB(t)
+	resetTOTPFailures(t)
+	user := createTestUser(t, "twostep")
+	now := setTestClock(t, time.Now())
+	err := saveTOTP(user.Id, rfcSecret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := newTestChallenge(t, user)
+
+	resp := postSigninTOTP(challenge, "000000", "192.0.2.1:1234")
+	if resp.Error == nil || resp.Error.Type != INVALID_TWO_FACTOR_CODE {
+		t.Fatalf("wrong code: got %+v", resp.Error)
+	}
+	// Challenge is used up by wrong code too
+	code, _ := totpCode(rfcSecret, now.Unix()/totpPeriod)
+	resp = postSigninTOTP(challenge, code, "192.0.2.1:1234")
+	if resp.Error == nil || resp.Error.Type != INVALID_TOKEN {
+		t.Fatalf("challenge after wrong code: got %+v", resp.Error)
+	}
+
+	// Ban issued between password and code
+	err = insertIpBan(&IpBan{Cidr: "192.0.2.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge = newTestChallenge(t, user)
+	resp = postSigninTOTP(challenge, code, "192.0.2.1:1234")
+	if resp.Error == nil || resp.Error.Type != IP_BANNED {
+		t.Fatalf("banned address: got %+v", resp.Error)
+	}
+
+	resp = postSigninTOTP(challenge, code, "198.51.100.1:1234")
+	if resp.Error != nil {
+		t.Fatalf("valid code: got %+v", resp.Error)
+	}
+	*now = now.Add(totpPeriod * time.Second)
+	code, _ = totpCode(rfcSecret, now.Unix()/totpPeriod)
+	resp = postSigninTOTP(challenge, code, "198.51.100.1:1234")
+	if resp.Error == nil || resp.Error.Type != INVALID_TOKEN {
+		t.Fatalf("challenge after sign in: got %+v", resp.Error)
+	}
+
+	for i := 0; i < totpMaxFailures; i++ {
+		postSigninTOTP(newTestChallenge(t, user), "000000", "198.51.100.1:1234")
+	}
+	resp = postSigninTOTP(newTestChallenge(t, user), code, "198.51.100.1:1234")
+	if resp.Error == nil || resp.Error.Type != TOO_MANY_REQUESTS {
+		t.Fatalf("locked out: got %+v", resp.Error)
+	}
+}
+
+func TestSigninTOTPDisabledMeanwhile(t *testing.T) {
+	setupTestDB(t)
+	resetTOTPFailures(t)
+	user := createTestUser(t, "switcher")
+	err := saveTOTP(user.Id, rfcSecret, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge := newTestChallenge(t, user)
+	err = saveTOTP(user.Id, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp := postSigninTOTP(challenge, "", "198.51.100.1:1234")
+	if resp.Error == nil || resp.Error.Type != INVALID_TOKEN || resp.Payload != nil {
+		t.Fatalf("challenge without two-factor authentication: got %+v %+v", resp.Error, resp.Payload)
+	}
+}
@@ -9,10 +9,28 @@ func contains(arr []*User, user User) bool {
 	return false
 }
 
-func setOnLineStatus(user *User, clients map[int]*Client) {
-	if _, ok := clients[user.Id]; ok {
+func setOnLineStatus(user *User) {
+	if _, ok := getClient(user.Id); ok {
 		user.OnLine = true
 	} else {
 		user.OnLine = false
 	}
 }
+
+func containsString(arr []string, s string) bool {
+	for _, item := range arr {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// First length characters of s, with "..." if s was cut
+func excerpt(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length]) + "..."
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Send email with link confirming that user owns email address.
+// Link stops working after VerificationTTL hours or when email is changed
+func sendVerificationEmail(user *User) error {
+	expires := getCurrentMilli() + int64(config.VerificationTTL)*60*60*1000
+	token, err := signToken("verify_email|"+user.Email, user.Id, expires)
+	if err != nil {
+		return err
+	}
+	link := config.BaseUrl + "/?" + url.Values{"verify_token": {token}}.Encode()
+	body := fmt.Sprintf("Hi %v,\n\nPlease confirm your email address by opening the link below:\n\n%v\n\nThe link expires in %v hours.\n", user.NickName, link, config.VerificationTTL)
+	return mailer.Send(user.Email, "Confirm your email", body)
+}
+
+// Returns error if user has not verified email and action is not allowed for unverified users
+func checkVerified(user *User, allowed bool) *Error {
+	if user.Verified || allowed {
+		return nil
+	}
+	return &Error{Type: EMAIL_NOT_VERIFIED, Message: "Error: please confirm your email address first"}
+}
+
+// Mark email as verified using token from verification email
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	token := r.FormValue("token")
+
+	user, err := getUserById(tokenUserId(token))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if user == nil || !verifyToken("verify_email|"+user.Email, token) {
+		resp.Error = &Error{Type: INVALID_TOKEN, Message: "Error: verification link is invalid or expired"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = setVerified(user.Id, true)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Send verification email again. Limited to one email per VerificationResendInterval seconds
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+
+	resp := Response{Payload: nil, Error: nil}
+
+	if r.Method != "POST" {
+		resp.Error = &Error{Type: WRONG_METHOD, Message: "Error: wrong http method"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	user, err := getUserBySessionId(r.FormValue("session_id"))
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if user == nil {
+		resp.Error = &Error{Type: NO_USER_FOUND, Message: "Error: unable to authorize user"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if user.Verified {
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	ok, err := updateVerificationSent(user.Id, int64(config.VerificationResendInterval)*1000)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_ACCESSING_DATABASE, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if !ok {
+		resp.Error = &Error{Type: TOO_MANY_REQUESTS, Message: "Error: verification email was sent recently, try again later"}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	err = sendVerificationEmail(user)
+	if err != nil {
+		resp.Error = &Error{Type: ERROR_SENDING_EMAIL, Message: fmt.Sprintf("Error: %v", err)}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
 )
@@ -12,6 +15,13 @@ type Client struct {
 	user           *User
 	conn           *websocket.Conn
 	messageChannel chan []byte
+	// Closed once client is removed, so that nobody waits for its writer
+	done chan bool
+
+	// Guards feed events client wants to receive, see feed.go,
+	// and fields of user that can change while connected
+	mutex sync.Mutex
+	feed  *FeedSubscription
 }
 
 // type Online_Users struct {
@@ -25,7 +35,28 @@ var upgrader = websocket.Upgrader{
 	CheckOrigin:     func(r *http.Request) bool { return true },
 }
 
+// Connected clients by user id, guarded by clientsMutex. Messages are sent
+// only after mutex is released, as sending waits for writer of client
 var clients = make(map[int]*Client)
+var clientsMutex sync.RWMutex
+
+func getClient(id int) (*Client, bool) {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	client, ok := clients[id]
+	return client, ok
+}
+
+// Copy of clients to iterate over without holding mutex
+func connectedClients() map[int]*Client {
+	clientsMutex.RLock()
+	defer clientsMutex.RUnlock()
+	connected := make(map[int]*Client, len(clients))
+	for id, client := range clients {
+		connected[id] = client
+	}
+	return connected
+}
 
 func addClient(user User, w http.ResponseWriter, r *http.Request) {
 
@@ -39,46 +70,87 @@ func addClient(user User, w http.ResponseWriter, r *http.Request) {
 		user:           &user,
 		conn:           ws,
 		messageChannel: make(chan []byte),
+		done:           make(chan bool),
 	}
+	// Earlier connection of same user is replaced
+	clientsMutex.Lock()
+	previous, ok := clients[user.Id]
 	clients[user.Id] = &client
+	clientsMutex.Unlock()
+	if ok {
+		closeClient(previous)
+	}
 
-	go writeMessage(user.Id)
-	go readMessages(user.Id)
+	go writeMessage(&client)
+	go readMessages(&client)
 
 }
 
 func removeClient(id int) {
-	if client, ok := clients[id]; ok {
-		client.conn.Close()
-		delete(clients, id)
-		fmt.Printf("Deleted: %v\n", id)
+	if client, ok := getClient(id); ok {
+		dropClient(client)
+	}
+}
+
+// Remove client unless it was already removed or replaced by newer connection
+func dropClient(client *Client) {
+	clientsMutex.Lock()
+	current := clients[client.user.Id] == client
+	if current {
+		delete(clients, client.user.Id)
+	}
+	clientsMutex.Unlock()
+	if current {
+		closeClient(client)
+	}
+}
+
+func closeClient(client *Client) {
+	client.conn.Close()
+	close(client.done)
+	fmt.Printf("Deleted: %v\n", client.user.Id)
+}
+
+// Close connection of user with close frame telling why
+func evictClient(id int, reason string) {
+	if client, ok := getClient(id); ok {
+		// Close reason is limited to 123 bytes and must stay valid UTF-8
+		if len(reason) > 123 {
+			cut := 123
+			for cut > 0 && !utf8.RuneStart(reason[cut]) {
+				cut--
+			}
+			reason = reason[:cut]
+		}
+		message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+		err := client.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		if err != nil {
+			fmt.Println(err)
+		}
+		dropClient(client)
 	}
 }
 
-func readMessages(id int) {
+func readMessages(client *Client) {
+	id := client.user.Id
 	defer func() {
-		removeClient(id)
+		dropClient(client)
 		broadcastClientsStatus()
 	}()
 	for {
-		_, message, err := clients[id].conn.ReadMessage()
+		_, message, err := client.conn.ReadMessage()
 		if err != nil {
 			// Error:  websocket: close 1001 (going away)
 			fmt.Println(err, " Connection: ", id)
 			return
 		}
-		fmt.Println("Message ", string(message))
-		message = []byte("Using channel. Message: " + string(message))
-		for _, conn := range clients {
-			conn.messageChannel <- message
-		}
+		handleClientCommand(client, message)
 	}
 }
 
-func writeMessage(id int) {
-	client := clients[id]
+func writeMessage(client *Client) {
 	defer func() {
-		removeClient(id)
+		dropClient(client)
 	}()
 	for {
 		select {
@@ -100,7 +172,7 @@ func writeMessage(id int) {
 
 func broadcastClientsStatus() {
 
-	for id, client := range clients {
+	for id, client := range connectedClients() {
 
 		//Get all users that chatted with current user/id
 
@@ -116,22 +188,36 @@ func broadcastClientsStatus() {
 			return
 		}
 
+		//Users who blocked current user are not shown
+		blockers, err := getBlockerIds(id)
+
+		if err != nil {
+			return
+		}
+
 		//Join chatMates and users
 		for _, user := range users {
 			if !contains(chatMates, *user) && user.Id != id {
 				chatMates = append(chatMates, user)
 			}
 		}
+		visible := []*User{}
+		for _, user := range chatMates {
+			if !blockers[user.Id] {
+				visible = append(visible, user)
+			}
+		}
+		chatMates = visible
 
 		//Mark on-line/off-line users
 		for _, user := range chatMates {
 			//Mark on-line/off-line
-			setOnLineStatus(user, clients)
+			setOnLineStatus(user)
 		}
 
 		message := `{"online_users":[`
 		for _, user := range chatMates {
-			message += fmt.Sprintf(`{"id": %v, "nick_name": "%v", "on_line": "%v"},`, user.Id, user.NickName, user.OnLine)
+			message += fmt.Sprintf(`{"id": %v, "nick_name": "%v", "avatar_url": "%v", "on_line": "%v"},`, user.Id, user.NickName, user.AvatarUrl, user.OnLine)
 		}
 		message = strings.TrimSuffix(message, ",")
 		message += `]}`
@@ -148,13 +234,35 @@ func broadcastClientsStatus() {
 		//message = strings.TrimSuffix(message, ",")
 
 		//message += `]}`
-		client.messageChannel <- []byte(message)
+		client.send([]byte(message))
 
 	}
 }
 
 func notifyClient(id int, message []byte) {
-	if client, ok := clients[id]; ok {
-		client.messageChannel <- message
+	if client, ok := getClient(id); ok {
+		client.send(message)
+	}
+}
+
+// Message is dropped if client is removed before its writer takes it
+func (client *Client) send(message []byte) {
+	select {
+	case client.messageChannel <- message:
+	case <-client.done:
 	}
 }
+
+// Nick name of connected user changed in profile
+func (client *Client) setNickName(nickName string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.user.NickName = nickName
+}
+
+// Role of connected user changed by administrator
+func (client *Client) setRole(role string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.user.Role = role
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Connect user to websocket of server, messages received are passed to returned channel
+func connectTestClient(t *testing.T, server *httptest.Server, user *User) (*websocket.Conn, chan string) {
+	t.Helper()
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/" + user.SessionId
+	conn, _, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan string, 1000)
+	go func() {
+		defer close(received)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(message)
+		}
+	}()
+	// Client is registered once its first status broadcast arrives
+	waitForMessage(t, received, "online_users")
+	return conn, received
+}
+
+func waitForMessage(t *testing.T, received chan string, substring string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case message, ok := <-received:
+			if !ok {
+				t.Fatalf("connection closed before %v", substring)
+			}
+			if strings.Contains(message, substring) {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v", substring)
+		}
+	}
+}
+
+func createTestSession(t *testing.T, nickName string) *User {
+	t.Helper()
+	user := createTestUser(t, nickName)
+	user.SessionId = generateSessionId()
+	err := updateSessionId(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func TestClientsConcurrentAccess(t *testing.T) {
+	setupTestDB(t)
+	server := httptest.NewServer(http.HandlerFunc(websocketHandler))
+	defer server.Close()
+	defer func() {
+		for id := range connectedClients() {
+			removeClient(id)
+		}
+	}()
+
+	first := createTestSession(t, "first")
+	second := createTestSession(t, "second")
+	connectTestClient(t, server, first)
+	secondConn, _ := connectTestClient(t, server, second)
+
+	// Messages are sent while clients come and go
+	stop := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				notifyClient(first.Id, []byte(`{"ping":1}`))
+				publishFeedEvent(&FeedEvent{Type: FEED_POST_CREATED})
+				setOnLineStatus(&User{Id: second.Id})
+			}
+		}()
+	}
+
+	secondConn.Close()
+	// Reconnect replaces earlier connection of same user
+	_, newer := connectTestClient(t, server, first)
+	close(stop)
+	wg.Wait()
+
+	notifyClient(first.Id, []byte(`{"pong":1}`))
+	waitForMessage(t, newer, "pong")
+	if _, ok := getClient(first.Id); !ok {
+		t.Fatal("newer connection was removed")
+	}
+}